@@ -0,0 +1,167 @@
+package buffer_manager
+
+import (
+	"sync"
+	"time"
+)
+
+// WriterConfig 后台写回脏页的配置
+type WriterConfig struct {
+	Interval      time.Duration // 两轮写回之间的间隔
+	MaxPages      int           // 每轮最多写回的页面数，用来限制写回的速度
+	HighWatermark float64       // 脏页占缓存池的比例达到这个值时开始写回
+	LowWatermark  float64       // 写回到脏页比例不超过这个值时停止
+}
+
+func DefaultWriterConfig() WriterConfig {
+	return WriterConfig{
+		Interval:      200 * time.Millisecond,
+		MaxPages:      16,
+		HighWatermark: 0.2,
+		LowWatermark:  0.05,
+	}
+}
+
+/*
+BackgroundWriter 周期性地把没有被pin的脏页写回磁盘，这样置换页面的时候大多数页面已经是干净的，
+Pin就不需要在关键路径上同步写磁盘。只在选择页面时持有BufferManager的锁，选中的页面被标记为正在写回，
+写磁盘时只持有页面的共享latch，其他协程可以继续pin和unpin，但是不能置换这个页面。
+Buffer.Flush会先调用LogManager.FlushByLSN，保证先写日志再写数据。
+*/
+type BackgroundWriter struct {
+	bufferManager *BufferManager
+	config        WriterConfig
+	cursor        int // 上一轮扫描到的位置，下一轮从这里继续
+	stop          chan struct{}
+	done          chan struct{}
+	mu            sync.Mutex
+}
+
+func NewBackgroundWriter(bufferManager *BufferManager, config WriterConfig) *BackgroundWriter {
+	defaults := DefaultWriterConfig()
+	if config.Interval <= 0 {
+		config.Interval = defaults.Interval
+	}
+	if config.MaxPages <= 0 {
+		config.MaxPages = defaults.MaxPages
+	}
+	if config.LowWatermark > config.HighWatermark {
+		config.LowWatermark = config.HighWatermark
+	}
+	return &BackgroundWriter{
+		bufferManager: bufferManager,
+		config:        config,
+	}
+}
+
+// Start 启动后台协程，重复调用没有效果
+func (w *BackgroundWriter) Start() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stop != nil {
+		return
+	}
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	go w.run(w.stop, w.done)
+}
+
+// Stop 停止后台协程，等待正在进行的写回结束
+func (w *BackgroundWriter) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stop == nil {
+		return
+	}
+	close(w.stop)
+	<-w.done
+	w.stop = nil
+	w.done = nil
+}
+
+func (w *BackgroundWriter) run(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			w.RunOnce()
+		}
+	}
+}
+
+// RunOnce 执行一轮写回，返回写回的页面数。脏页数只在每轮开始时统计一次，之后每写回一页减一，
+// 这一轮中其他协程新产生的脏页留到下一轮处理
+func (w *BackgroundWriter) RunOnce() int {
+	b := w.bufferManager
+	total, dirty := w.countDirty(b)
+	if total == 0 || float64(dirty)/float64(total) < w.config.HighWatermark {
+		return 0
+	}
+
+	written := 0
+	for written < w.config.MaxPages && float64(dirty)/float64(total) > w.config.LowWatermark {
+		if !w.writeNext(b) {
+			break
+		}
+		written++
+		dirty--
+	}
+	return written
+}
+
+// countDirty 返回缓存池的大小和其中脏页的数量
+func (w *BackgroundWriter) countDirty(b *BufferManager) (int, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	dirty := 0
+	for _, buffer := range b.bufferPool {
+		if buffer.IsModified() {
+			dirty++
+		}
+	}
+	return len(b.bufferPool), dirty
+}
+
+// writeNext 从上次的位置开始写回下一个没有被pin的脏页，没有可写的页面时返回false
+func (w *BackgroundWriter) writeNext(b *BufferManager) bool {
+	buffer := w.nextDirty(b)
+	if buffer == nil {
+		return false
+	}
+	err := buffer.Flush()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.flushing, buffer)
+	b.cond.Broadcast() // 唤醒等待置换这个页面的协程
+	if err != nil {
+		return false
+	}
+	b.stats.BackgroundWrites++
+	return true
+}
+
+// nextDirty 找到下一个没有被pin的脏页并标记为正在写回
+func (w *BackgroundWriter) nextDirty(b *BufferManager) *Buffer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	total := len(b.bufferPool)
+	for i := 0; i < total; i++ {
+		buffer := b.bufferPool[(w.cursor+i)%total]
+		if buffer.IsPinned() || !buffer.IsModified() {
+			continue
+		}
+		w.cursor = (w.cursor + i + 1) % total
+		b.flushing[buffer] = true
+		return buffer
+	}
+	return nil
+}
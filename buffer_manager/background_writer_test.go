@@ -0,0 +1,116 @@
+package buffer_manager
+
+import (
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	fm "simpleDb/file_manager"
+	lm "simpleDb/log_manager"
+	"testing"
+	"time"
+)
+
+func TestBackgroundWriter_RunOnce(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "buffertest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "logfile")
+	bufferManager := NewBufferManager(fileManager, logManager, 4)
+
+	buffers := make([]*Buffer, 4)
+	for i := range buffers {
+		buffer, err := bufferManager.Pin(fm.NewBlockId("testfile", uint64(i)))
+		require.Nil(t, err)
+		buffer.Contents().SetInt(80, uint64(100+i))
+		lsn, _ := logManager.Append([]byte("record"))
+		buffer.SetModified(1, lsn)
+		buffers[i] = buffer
+	}
+	// 被pin的脏页不能写回
+	bufferManager.Unpin(buffers[0])
+	bufferManager.Unpin(buffers[1])
+	bufferManager.Unpin(buffers[2])
+
+	writer := NewBackgroundWriter(bufferManager, WriterConfig{MaxPages: 1, HighWatermark: 0.6, LowWatermark: 0.25})
+	require.Equal(t, 1, writer.RunOnce())
+	require.Equal(t, uint32(3), bufferManager.Stats().Dirty)
+	require.Equal(t, 1, writer.RunOnce())
+	// 脏页比例2/4没有达到高水位
+	require.Equal(t, 0, writer.RunOnce())
+
+	writer = NewBackgroundWriter(bufferManager, WriterConfig{MaxPages: 10, HighWatermark: 0.5, LowWatermark: 0})
+	require.Equal(t, 1, writer.RunOnce())
+	stats := bufferManager.Stats()
+	require.Equal(t, uint32(1), stats.Dirty)
+	require.Equal(t, uint64(3), stats.BackgroundWrites)
+	require.True(t, buffers[3].IsModified())
+
+	// 写回的数据已经在磁盘上
+	page := fm.NewPageBySize(400)
+	fileManager.Read(fm.NewBlockId("testfile", 2), page)
+	require.Equal(t, uint64(102), page.GetInt(80))
+}
+
+func TestBackgroundWriter_StartStop(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "buffertest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "logfile")
+	bufferManager := NewBufferManager(fileManager, logManager, 2)
+
+	buffer, err := bufferManager.Pin(fm.NewBlockId("testfile", 1))
+	require.Nil(t, err)
+	buffer.SetModified(1, 0)
+	bufferManager.Unpin(buffer)
+
+	writer := NewBackgroundWriter(bufferManager, WriterConfig{Interval: 5 * time.Millisecond, HighWatermark: 0.1})
+	writer.Start()
+	writer.Start()
+	require.Eventually(t, func() bool {
+		return bufferManager.Stats().Dirty == 0
+	}, time.Second, 5*time.Millisecond)
+	writer.Stop()
+	writer.Stop()
+}
+
+func TestBackgroundWriter_FlushOutsidePoolLock(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "buffertest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "logfile")
+	bufferManager := NewBufferManager(fileManager, logManager, 2)
+
+	dirty, err := bufferManager.Pin(fm.NewBlockId("testfile", 0))
+	require.Nil(t, err)
+	dirty.SetModified(1, 0)
+	bufferManager.Unpin(dirty)
+	clean, err := bufferManager.Pin(fm.NewBlockId("testfile", 1))
+	require.Nil(t, err)
+	bufferManager.Unpin(clean)
+
+	// 写回区块0时卡住，直到测试放行
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	fileManager.SetWriteHook(func(blk *fm.BlockId) error {
+		if blk.FileName() == "testfile" && blk.Number() == 0 {
+			close(entered)
+			<-release
+		}
+		return nil
+	})
+	writer := NewBackgroundWriter(bufferManager, WriterConfig{MaxPages: 1, HighWatermark: 0.5})
+	written := make(chan int)
+	go func() {
+		written <- writer.RunOnce()
+	}()
+	<-entered
+
+	// 写磁盘期间其他页面可以正常pin和unpin，正在写回的页面也可以被pin
+	buffer, err := bufferManager.Pin(fm.NewBlockId("testfile", 1))
+	require.Nil(t, err)
+	require.Equal(t, clean, buffer)
+	require.Nil(t, bufferManager.Unpin(buffer))
+	buffer, err = bufferManager.Pin(fm.NewBlockId("testfile", 0))
+	require.Nil(t, err)
+	require.Equal(t, dirty, buffer)
+	require.Nil(t, bufferManager.Unpin(buffer))
+	require.Equal(t, uint32(2), bufferManager.Available())
+
+	close(release)
+	require.Equal(t, 1, <-written)
+	require.False(t, dirty.IsModified())
+	fileManager.SetWriteHook(nil)
+}
@@ -3,28 +3,37 @@ package buffer_manager
 import (
 	fmgr "simpleDb/file_manager"
 	lmgr "simpleDb/log_manager"
+	"sync"
+	"sync/atomic"
 )
 
+/*
+Buffer 缓存页面。页面内容由latch保护，latch是短期的读写锁，只在读写页面数据的时候持有，
+与事务的锁无关：读数据前调用LatchShared，修改数据前调用LatchExclusive。持有latch期间不能再去pin或者unpin页面。
+pins、txNum、lsn和recLSN使用原子操作，可以在不持有latch的情况下读取。
+*/
 type Buffer struct {
 	fm       *fmgr.FileManager
 	lm       *lmgr.LogManager
 	contents *fmgr.Page
 	blk      *fmgr.BlockId
-	pins     uint32 // 引用计数
-	txNum    int32  // 事务号
+	txNum    uint64 // 修改页面的事务号，0表示页面没有被修改
 	lsn      uint64 // 日志号
+	recLSN   uint64 // 页面写回磁盘之后第一次修改的日志号，0表示还没有记录日志的修改
+	pins     int32  // 引用计数
+	latch    sync.RWMutex
 }
 
 func NewBuffer(fm *fmgr.FileManager, lm *lmgr.LogManager) *Buffer {
 	return &Buffer{
 		fm:       fm,
 		lm:       lm,
-		txNum:    -1,
 		lsn:      0,
 		contents: fmgr.NewPageBySize(fm.BlockSize()),
 	}
 }
 
+// Contents 返回页面数据，调用者需要持有latch
 func (b *Buffer) Contents() *fmgr.Page {
 	return b.contents
 }
@@ -33,42 +42,89 @@ func (b *Buffer) Block() *fmgr.BlockId {
 	return b.blk
 }
 
-func (b *Buffer) SetModified(txNum int32, lsn uint64) {
+func (b *Buffer) LatchShared() {
+	b.latch.RLock()
+}
+
+func (b *Buffer) UnlatchShared() {
+	b.latch.RUnlock()
+}
+
+func (b *Buffer) LatchExclusive() {
+	b.latch.Lock()
+}
+
+func (b *Buffer) UnlatchExclusive() {
+	b.latch.Unlock()
+}
+
+func (b *Buffer) SetModified(txNum uint64, lsn uint64) {
 	// 如果上层组件修改了缓存数据，必须调用这个接口进行通知
-	b.txNum = txNum
+	atomic.StoreUint64(&b.txNum, txNum)
 	if lsn > 0 {
-		b.lsn = lsn
+		atomic.StoreUint64(&b.lsn, lsn)
+		atomic.CompareAndSwapUint64(&b.recLSN, 0, lsn)
 	}
 }
 
 func (b *Buffer) IsPinned() bool {
 	// 返回当前缓存数据是否在被使用
-	return b.pins > 0
+	return atomic.LoadInt32(&b.pins) > 0
 }
 
-func (b *Buffer) ModifyingTx() int32 {
-	return b.txNum
+// IsModified 返回页面是否被修改过还没有写回磁盘
+func (b *Buffer) IsModified() bool {
+	return atomic.LoadUint64(&b.txNum) != 0
+}
+
+// RecLSN 返回页面写回磁盘之后第一次修改的日志号，恢复时从这条日志开始重做这个页面
+func (b *Buffer) RecLSN() uint64 {
+	return atomic.LoadUint64(&b.recLSN)
+}
+
+func (b *Buffer) ModifyingTx() uint64 {
+	return atomic.LoadUint64(&b.txNum)
 }
 
 func (b *Buffer) AssignToBlock(block *fmgr.BlockId) {
 	// 将指定的文件区块号的内容读到buffer中
-	b.Flush() // 当页面读取其他数据时，先当前数据写入磁盘
+	b.latch.Lock()
+	defer b.latch.Unlock()
+
+	b.flush() // 当页面读取其他数据时，先当前数据写入磁盘
 	b.blk = block
 	b.fm.Read(b.blk, b.Contents()) // 将对应的磁盘区块数据读入到缓存中
-	b.pins = 0
+	atomic.StoreInt32(&b.pins, 0)
 }
 
-func (b *Buffer) Flush() {
-	if b.txNum > 0 {
-		b.lm.FlushByLSN(b.lsn)          // 为系统崩溃恢复提供支持
-		b.fm.Write(b.blk, b.Contents()) // 将已经修改的数据写入到磁盘
-		b.txNum = -1
+func (b *Buffer) Flush() error {
+	// 写磁盘期间不允许其他协程修改页面
+	b.latch.RLock()
+	defer b.latch.RUnlock()
+
+	return b.flush()
+}
+
+func (b *Buffer) flush() error {
+	if b.IsModified() {
+		// 先写日志再写数据，为系统崩溃恢复提供支持
+		if err := b.lm.FlushByLSN(atomic.LoadUint64(&b.lsn)); err != nil {
+			return err
+		}
+		// 将已经修改的数据写入到磁盘
+		if _, err := b.fm.Write(b.blk, b.Contents()); err != nil {
+			return err
+		}
+		atomic.StoreUint64(&b.txNum, 0)
+		atomic.StoreUint64(&b.recLSN, 0)
 	}
+	return nil
 }
 
 func (b *Buffer) Pin() {
-	b.pins = b.pins + 1
+	atomic.AddInt32(&b.pins, 1)
 }
+
 func (b *Buffer) Unpin() {
-	b.pins = b.pins - 1
+	atomic.AddInt32(&b.pins, -1)
 }
@@ -1,7 +1,9 @@
 package buffer_manager
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	fm "simpleDb/file_manager"
 	lm "simpleDb/log_manager"
 	"sync"
@@ -12,93 +14,273 @@ const (
 	MAX_TIME = 3 // 分配缓存最多等待的时间
 )
 
+// ErrBufferAbort 等待缓存页面超时或者被取消，上层的事务应该回滚以释放自己持有的页面
+var ErrBufferAbort = errors.New("no buffer available, careful for dead lock")
+
+// BufferAbortError 记录了等待哪一个区块时放弃，可以用errors.Is(err, ErrBufferAbort)判断
+type BufferAbortError struct {
+	Blk *fm.BlockId
+	Err error // 导致放弃等待的原因，通常是context的错误
+}
+
+func (e *BufferAbortError) Error() string {
+	return fmt.Sprintf("%v: waiting for blk %d of file %s: %v", ErrBufferAbort, e.Blk.Number(), e.Blk.FileName(), e.Err)
+}
+
+func (e *BufferAbortError) Is(target error) bool {
+	return target == ErrBufferAbort
+}
+
+func (e *BufferAbortError) Unwrap() error {
+	return e.Err
+}
+
+// blockKey 页表的键，用文件名和区块号唯一确定一个区块
+type blockKey struct {
+	fileName string
+	blkNum   uint64
+}
+
+func keyOf(blk *fm.BlockId) blockKey {
+	return blockKey{fileName: blk.FileName(), blkNum: blk.Number()}
+}
+
 type BufferManager struct {
+	fm           *fm.FileManager
+	lm           *lm.LogManager
 	bufferPool   []*Buffer
-	numAvailable uint32
+	pageTable    map[blockKey]*Buffer // 区块到缓存页面的映射
+	freeList     []*Buffer            // 还没有分配过区块的页面，优先使用
+	numAvailable uint32               // 没有被pin的页面数，包括被预留的页面
+	reserved     uint32               // 预留给Reservation还没有使用的页面数
+	policy       ReplacementPolicy    // 缓存置换策略
+	shrinkPolicy ShrinkPolicy         // 缩小缓存池时遇到被pin的页面怎么处理
 	mu           sync.Mutex
+	cond         *sync.Cond // 等待可用页面的协程在这里睡眠，有页面被unpin时唤醒
+	stats        Stats
+	fileStats    map[string]*FileStats
+	pinHolders   map[*Buffer]map[string]*pinHold // 每个页面被哪些持有者pin了
+	flushing     map[*Buffer]bool                // 后台写回正在写磁盘的页面，写完之前不能被置换
+	pinDebug     bool
+}
+
+// Option 用于在创建BufferManager的时候修改默认配置
+type Option func(b *BufferManager)
+
+// WithReplacementPolicy 指定缓存置换策略，默认使用NaivePolicy
+func WithReplacementPolicy(policy ReplacementPolicy) Option {
+	return func(b *BufferManager) {
+		b.policy = policy
+	}
 }
 
-func NewBufferManager(fm *fm.FileManager, lm *lm.LogManager, numAvailable uint32) *BufferManager {
+func NewBufferManager(fm *fm.FileManager, lm *lm.LogManager, numAvailable uint32, opts ...Option) *BufferManager {
 	bufferManager := &BufferManager{
+		fm:           fm,
+		lm:           lm,
 		numAvailable: numAvailable,
+		policy:       NewNaivePolicy(),
+		pageTable:    make(map[blockKey]*Buffer),
+		fileStats:    make(map[string]*FileStats),
+		pinHolders:   make(map[*Buffer]map[string]*pinHold),
+		flushing:     make(map[*Buffer]bool),
+	}
+	bufferManager.cond = sync.NewCond(&bufferManager.mu)
+	for _, opt := range opts {
+		opt(bufferManager)
 	}
 	for i := uint32(0); i < numAvailable; i++ {
 		buffer := NewBuffer(fm, lm)
 		bufferManager.bufferPool = append(bufferManager.bufferPool, buffer)
+		bufferManager.policy.Add(buffer)
+	}
+	// 倒序放入，这样空闲页面按照缓存池的顺序被使用
+	for i := len(bufferManager.bufferPool) - 1; i >= 0; i-- {
+		bufferManager.freeList = append(bufferManager.freeList, bufferManager.bufferPool[i])
 	}
 
 	return bufferManager
 }
 
+func (b *BufferManager) Policy() ReplacementPolicy {
+	return b.policy
+}
+
+// Available 返回可以被普通的Pin使用的页面数，被预留的页面不计算在内
 func (b *BufferManager) Available() uint32 {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	return b.numAvailable
+	return b.unreserved()
+}
+
+func (b *BufferManager) unreserved() uint32 {
+	if b.numAvailable <= b.reserved {
+		return 0
+	}
+	return b.numAvailable - b.reserved
 }
 
-func (b *BufferManager) FlushAll(txNum int32) {
-	// 将给定事务的数据全部写入到磁盘
+// FlushAll 将给定事务的数据全部写入到磁盘，某个页面写入失败时继续写入其他页面，返回遇到的第一个错误
+func (b *BufferManager) FlushAll(txNum uint64) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	var firstErr error
 	for _, buffer := range b.bufferPool {
-		if buffer.txNum == txNum {
-			buffer.Flush()
+		if buffer.ModifyingTx() == txNum {
+			if err := buffer.Flush(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// DirtyPage 脏页表中的一项，RecLSN是页面写回磁盘之后第一次修改的日志号
+type DirtyPage struct {
+	Blk    *fm.BlockId
+	RecLSN uint64
+}
+
+/*
+DirtyPages 返回缓存池中所有记录了日志的脏页，用于非静止检查点。
+读取每个页面时持有它的共享latch，正在修改页面的事务写完日志并标记页面之后这里才能读到，
+因此调用之前已经写入的日志，它修改的页面要么在返回的结果中，要么已经写回了磁盘
+*/
+func (b *BufferManager) DirtyPages() []DirtyPage {
+	b.mu.Lock()
+	pool := make([]*Buffer, len(b.bufferPool))
+	copy(pool, b.bufferPool)
+	b.mu.Unlock()
+
+	pages := make([]DirtyPage, 0)
+	for _, buffer := range pool {
+		buffer.LatchShared()
+		if buffer.IsModified() && buffer.RecLSN() > 0 && buffer.Block() != nil {
+			pages = append(pages, DirtyPage{Blk: buffer.Block(), RecLSN: buffer.RecLSN()})
 		}
+		buffer.UnlatchShared()
 	}
+	return pages
 }
 
 func (b *BufferManager) Pin(blk *fm.BlockId) (*Buffer, error) {
-	// 将给定磁盘的区块数据分配给缓存页面
+	// 将给定磁盘的区块数据分配给缓存页面，最多等待MAX_TIME秒
+	ctx, cancel := context.WithTimeout(context.Background(), MAX_TIME*time.Second)
+	defer cancel()
+	return b.PinContext(ctx, blk)
+}
+
+/*
+PinContext 与Pin相同，但是等待可用页面的时间由ctx决定，ctx超时或者被取消时返回BufferAbortError。
+pin会记录在WithPinOwner指定的持有者名下，这样的pin需要用UnpinAs释放。
+*/
+func (b *BufferManager) PinContext(ctx context.Context, blk *fm.BlockId) (*Buffer, error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	buff, err := b.waitPin(ctx, blk, nil)
+	if err != nil {
+		return nil, err
+	}
+	b.trackPin(buff, pinOwnerOf(ctx))
+	return buff, nil
+}
+
+func (b *BufferManager) waitPin(ctx context.Context, blk *fm.BlockId, r *Reservation) (*Buffer, error) {
+	buff := b.tryPin(blk, r) // 尝试分配缓存
+	if buff != nil {
+		return buff, nil
+	}
+
 	start := time.Now()
-	buff := b.tryPin(blk) // 尝试分配缓存
-	for buff == nil && b.waitingToLong(start) == false {
-		// 如果无法分配缓存页面，等待一段时间再看看有没有可用的缓存页面
-		time.Sleep(MAX_TIME * time.Second)
-		buff = b.tryPin(blk)
-		if buff == nil {
-			return nil, errors.New("no buffer available , careful for dead lock")
+	defer func() {
+		b.recordWait(time.Since(start))
+	}()
+	stop := b.wakeOnDone(ctx)
+	defer stop()
+
+	for buff == nil {
+		if err := ctx.Err(); err != nil {
+			return nil, &BufferAbortError{Blk: blk, Err: err}
 		}
+		// Wait会释放锁，其他协程可以在等待期间unpin页面
+		b.cond.Wait()
+		buff = b.tryPin(blk, r)
 	}
 	return buff, nil
 }
 
-func (b *BufferManager) Unpin(buffer *Buffer) {
+// wakeOnDone cond不支持超时，ctx结束的时候由一个协程唤醒所有等待者，让它们检查自己的ctx。
+// 返回的函数用来结束这个协程
+func (b *BufferManager) wakeOnDone(ctx context.Context) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			b.mu.Lock()
+			b.cond.Broadcast()
+			b.mu.Unlock()
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+	}
+}
+
+func (b *BufferManager) Unpin(buffer *Buffer) error {
+	return b.UnpinAs(buffer, ANONYMOUS_OWNER)
+}
+
+// UnpinAs 释放给定持有者对页面的pin，页面不是由owner pin的时候返回ErrPinOwner，引用计数不变
+func (b *BufferManager) UnpinAs(buffer *Buffer, owner string) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	return b.unpin(buffer, owner)
+}
+
+// unpin 调用者需要持有锁
+func (b *BufferManager) unpin(buffer *Buffer, owner string) error {
 	if buffer == nil {
-		return
+		return nil
+	}
+	if err := b.untrackPin(buffer, owner); err != nil {
+		return err
 	}
 	buffer.Unpin()
 	if !buffer.IsPinned() {
 		b.numAvailable = b.numAvailable + 1
-		// notifyAll() 唤醒所有等待的线程，暂时不考虑，属于并发管理器的内容
-	}
-}
-
-func (b *BufferManager) waitingToLong(start time.Time) bool {
-	seconds := time.Since(start).Seconds()
-	if seconds >= MAX_TIME {
-		return true
+		b.policy.Unpinned(buffer)
+		b.cond.Broadcast() // 唤醒所有等待页面的协程
 	}
-	return false
+	return nil
 }
 
-func (b *BufferManager) tryPin(blk *fm.BlockId) *Buffer {
+// tryPin r不为空时使用预留给r的页面，否则只能使用没有被预留的页面
+func (b *BufferManager) tryPin(blk *fm.BlockId, r *Reservation) *Buffer {
 	// 首先看给定的区块是否已将再缓冲池中了
 	buffer := b.findExistingBuffer(blk)
+	needFrame := buffer == nil || !buffer.IsPinned()
+	if needFrame && r == nil && b.unreserved() == 0 {
+		return nil
+	}
+	newBlock := false
 	if buffer == nil {
 		// 查看是否还有可用的缓冲页面，有的话将给定磁盘块的数据写入缓存
 		buffer = b.chooseUnpinBuffer()
 		if buffer == nil {
 			return nil
 		}
+		if old := buffer.Block(); old != nil {
+			delete(b.pageTable, keyOf(old))
+			b.recordEviction(old.FileName(), buffer.IsModified())
+		}
 		buffer.AssignToBlock(blk)
+		b.pageTable[keyOf(blk)] = buffer
+		newBlock = true
 	}
 
 	if buffer.IsPinned() == false {
@@ -106,24 +288,26 @@ func (b *BufferManager) tryPin(blk *fm.BlockId) *Buffer {
 	}
 
 	buffer.Pin()
+	b.policy.Pinned(buffer, newBlock)
+	b.recordPin(blk.FileName(), !newBlock)
 	return buffer
 }
 
 func (b *BufferManager) findExistingBuffer(blk *fm.BlockId) *Buffer {
-	for _, buffer := range b.bufferPool {
-		block := buffer.Block()
-		if block != nil && block.Equal(blk) {
-			return buffer
-		}
-	}
-	return nil
+	return b.pageTable[keyOf(blk)]
 }
 
 func (b *BufferManager) chooseUnpinBuffer() *Buffer {
-	for _, buffer := range b.bufferPool {
-		if !buffer.IsPinned() {
-			return buffer
-		}
+	// 先使用空闲页面，没有的话由置换策略决定使用哪一个没有被pin的页面
+	if n := len(b.freeList); n > 0 {
+		buffer := b.freeList[n-1]
+		b.freeList = b.freeList[:n-1]
+		return buffer
 	}
-	return nil
+	victim := b.policy.Victim()
+	if victim != nil && b.flushing[victim] {
+		// 页面正在被后台写回，调用者等待写回结束时的唤醒
+		return nil
+	}
+	return victim
 }
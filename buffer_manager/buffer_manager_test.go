@@ -1,11 +1,15 @@
 package buffer_manager
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/require"
+	"path/filepath"
 	fm "simpleDb/file_manager"
 	lm "simpleDb/log_manager"
 	"testing"
+	"time"
 )
 
 func TestBufferManager_Available(t *testing.T) {
@@ -47,3 +51,195 @@ func TestBufferManager_Available(t *testing.T) {
 	n1 := page.GetInt(80)
 	require.Equal(t, n1, n+1)
 }
+
+func TestBufferManager_PinWaitsForUnpin(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "buffertest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "logfile")
+	bufferManager := NewBufferManager(fileManager, logManager, 1)
+
+	buf1, err := bufferManager.Pin(fm.NewBlockId("testfile", 1))
+	require.Nil(t, err)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		bufferManager.Unpin(buf1)
+	}()
+
+	// 页面被unpin之后等待者应该马上被唤醒，而不是等到超时
+	start := time.Now()
+	buf2, err := bufferManager.Pin(fm.NewBlockId("testfile", 2))
+	require.Nil(t, err)
+	require.Equal(t, uint64(2), buf2.Block().Number())
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestBufferManager_PinContext(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "buffertest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "logfile")
+	bufferManager := NewBufferManager(fileManager, logManager, 1)
+
+	_, err := bufferManager.Pin(fm.NewBlockId("testfile", 1))
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = bufferManager.PinContext(ctx, fm.NewBlockId("testfile", 2))
+	require.True(t, errors.Is(err, ErrBufferAbort))
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	var abortErr *BufferAbortError
+	require.True(t, errors.As(err, &abortErr))
+	require.Equal(t, uint64(2), abortErr.Blk.Number())
+}
+
+func TestBufferManager_PageTable(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "buffertest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "logfile")
+	bufferManager := NewBufferManager(fileManager, logManager, 2)
+
+	buf1, err := bufferManager.Pin(fm.NewBlockId("testfile", 1))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(bufferManager.freeList))
+	// 同一个区块用新的BlockId对象去pin也要找到原来的页面
+	same, err := bufferManager.Pin(fm.NewBlockId("testfile", 1))
+	require.Nil(t, err)
+	require.Equal(t, buf1, same)
+	bufferManager.Unpin(same)
+	bufferManager.Unpin(buf1)
+
+	buf2, err := bufferManager.Pin(fm.NewBlockId("testfile", 2))
+	require.Nil(t, err)
+	require.Equal(t, 0, len(bufferManager.freeList))
+	buf3, err := bufferManager.Pin(fm.NewBlockId("testfile", 3))
+	require.Nil(t, err)
+	require.Equal(t, buf1, buf3) // 区块1被置换出去
+
+	require.Nil(t, bufferManager.findExistingBuffer(fm.NewBlockId("testfile", 1)))
+	require.Equal(t, buf2, bufferManager.findExistingBuffer(fm.NewBlockId("testfile", 2)))
+	require.Equal(t, 2, len(bufferManager.pageTable))
+}
+
+func TestBufferManager_DirtyPages(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "buffertest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "logfile")
+	bufferManager := NewBufferManager(fileManager, logManager, 3)
+
+	buf1, err := bufferManager.Pin(fm.NewBlockId("testfile", 1))
+	require.Nil(t, err)
+	buf2, err := bufferManager.Pin(fm.NewBlockId("testfile", 2))
+	require.Nil(t, err)
+	lsn1, _ := logManager.Append([]byte("record1"))
+	buf1.SetModified(1, lsn1)
+	lsn2, _ := logManager.Append([]byte("record2"))
+	// 再次修改不改变recLSN
+	buf1.SetModified(1, lsn2)
+	buf2.SetModified(2, lsn2)
+	require.Equal(t, lsn1, buf1.RecLSN())
+
+	pages := bufferManager.DirtyPages()
+	require.Equal(t, 2, len(pages))
+	recLSNs := make(map[uint64]uint64)
+	for _, page := range pages {
+		recLSNs[page.Blk.Number()] = page.RecLSN
+	}
+	require.Equal(t, map[uint64]uint64{1: lsn1, 2: lsn2}, recLSNs)
+
+	// 写回磁盘之后不再是脏页
+	require.Nil(t, buf1.Flush())
+	require.Equal(t, uint64(0), buf1.RecLSN())
+	pages = bufferManager.DirtyPages()
+	require.Equal(t, 1, len(pages))
+	require.Equal(t, uint64(2), pages[0].Blk.Number())
+}
+
+func TestBufferManager_Stats(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "buffertest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "logfile")
+	bufferManager := NewBufferManager(fileManager, logManager, 2)
+
+	buf1, _ := bufferManager.Pin(fm.NewBlockId("file_a", 1))
+	buf1.SetModified(1, 0)
+	bufferManager.Unpin(buf1)
+	buf2, _ := bufferManager.Pin(fm.NewBlockId("file_b", 1))
+	buf1, _ = bufferManager.Pin(fm.NewBlockId("file_a", 1)) // 命中
+	bufferManager.Unpin(buf1)
+
+	stats := bufferManager.Stats()
+	require.Equal(t, uint64(3), stats.Pins)
+	require.Equal(t, uint64(1), stats.Hits)
+	require.Equal(t, uint64(2), stats.Misses)
+	require.Equal(t, uint32(1), stats.Pinned)
+	require.Equal(t, uint32(1), stats.Dirty)
+	require.InDelta(t, 1.0/3, stats.HitRatio(), 0.001)
+
+	// 区块file_a:1被置换，需要先写回磁盘
+	_, err := bufferManager.Pin(fm.NewBlockId("file_b", 2))
+	require.Nil(t, err)
+	stats = bufferManager.Stats()
+	require.Equal(t, uint64(1), stats.Evictions)
+	require.Equal(t, uint64(1), stats.DirtyEvictions)
+	require.Equal(t, uint64(1), stats.Files["file_a"].Evictions)
+	require.Equal(t, uint64(2), stats.Files["file_a"].Pins)
+	require.Equal(t, uint64(2), stats.Files["file_b"].Misses)
+	require.Equal(t, uint32(0), stats.Dirty)
+
+	// 没有可用页面时等待
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = bufferManager.PinContext(ctx, fm.NewBlockId("file_b", 3))
+	require.NotNil(t, err)
+	stats = bufferManager.Stats()
+	require.Equal(t, uint64(1), stats.Waits)
+	require.True(t, stats.WaitTime >= 20*time.Millisecond)
+
+	bufferManager.ResetStats()
+	stats = bufferManager.Stats()
+	require.Equal(t, uint64(0), stats.Pins)
+	require.Equal(t, 0, len(stats.Files))
+	require.Equal(t, uint32(2), stats.Pinned)
+	bufferManager.Unpin(buf2)
+}
+
+func TestBuffer_Latch(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "buffertest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "logfile")
+	bufferManager := NewBufferManager(fileManager, logManager, 2)
+
+	blk := fm.NewBlockId("testfile", 1)
+	// 协程里不能调用require，错误通过channel交给主协程检查
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		go func(txNum uint64) {
+			errs <- func() error {
+				buffer, err := bufferManager.Pin(blk)
+				if err != nil {
+					return err
+				}
+				defer bufferManager.Unpin(buffer)
+				for j := 0; j < 100; j++ {
+					buffer.LatchExclusive()
+					p := buffer.Contents()
+					p.SetInt(80, p.GetInt(80)+1)
+					buffer.SetModified(txNum, 0)
+					buffer.UnlatchExclusive()
+
+					buffer.LatchShared()
+					val := buffer.Contents().GetInt(80)
+					buffer.UnlatchShared()
+					if val == 0 || !buffer.IsModified() {
+						return fmt.Errorf("tx %d: value %d, modified %v", txNum, val, buffer.IsModified())
+					}
+				}
+				return nil
+			}()
+		}(uint64(i + 1))
+	}
+	for i := 0; i < 8; i++ {
+		require.Nil(t, <-errs)
+	}
+
+	buffer, _ := bufferManager.Pin(blk)
+	require.Equal(t, uint64(800), buffer.Contents().GetInt(80))
+	require.Nil(t, buffer.Flush())
+	require.False(t, buffer.IsModified())
+}
@@ -0,0 +1,156 @@
+package buffer_manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	fm "simpleDb/file_manager"
+	"sort"
+	"strings"
+)
+
+// ANONYMOUS_OWNER 没有通过WithPinOwner指定持有者时使用的名字
+const ANONYMOUS_OWNER = "anonymous"
+
+var (
+	ErrPinLeak  = errors.New("buffers still pinned")
+	ErrPinOwner = errors.New("buffer not pinned by this owner")
+)
+
+type pinOwnerKey struct{}
+
+// WithPinOwner 给ctx附上pin的持有者，通过PinContext pin页面时会记录在这个持有者名下
+func WithPinOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, pinOwnerKey{}, owner)
+}
+
+func pinOwnerOf(ctx context.Context) string {
+	if owner, ok := ctx.Value(pinOwnerKey{}).(string); ok && owner != "" {
+		return owner
+	}
+	return ANONYMOUS_OWNER
+}
+
+// WithPinDebug 打开后每次pin都会记录调用栈，DumpPins会把没有unpin的调用栈打印出来
+func WithPinDebug() Option {
+	return func(b *BufferManager) {
+		b.pinDebug = true
+	}
+}
+
+// PinInfo 某个持有者在一个区块上的pin
+type PinInfo struct {
+	Blk    *fm.BlockId
+	Owner  string
+	Count  int
+	Stacks []string // 只有打开WithPinDebug时才有
+}
+
+type pinHold struct {
+	count  int
+	stacks []string
+}
+
+func (b *BufferManager) trackPin(buffer *Buffer, owner string) {
+	holders, ok := b.pinHolders[buffer]
+	if !ok {
+		holders = make(map[string]*pinHold)
+		b.pinHolders[buffer] = holders
+	}
+	hold, ok := holders[owner]
+	if !ok {
+		hold = &pinHold{}
+		holders[owner] = hold
+	}
+	hold.count++
+	if b.pinDebug {
+		hold.stacks = append(hold.stacks, string(debug.Stack()))
+	}
+}
+
+// untrackPin 持有者没有pin过这个页面时返回ErrPinOwner，记录保持不变
+func (b *BufferManager) untrackPin(buffer *Buffer, owner string) error {
+	holders := b.pinHolders[buffer]
+	hold, ok := holders[owner]
+	if !ok {
+		return fmt.Errorf("%w: %s unpins %v", ErrPinOwner, owner, buffer.Block())
+	}
+	hold.count--
+	if len(hold.stacks) > 0 {
+		hold.stacks = hold.stacks[:len(hold.stacks)-1]
+	}
+	if hold.count <= 0 {
+		delete(holders, owner)
+	}
+	if len(holders) == 0 {
+		delete(b.pinHolders, buffer)
+	}
+	return nil
+}
+
+func (b *BufferManager) pinInfos(owner string) []PinInfo {
+	infos := make([]PinInfo, 0)
+	for buffer, holders := range b.pinHolders {
+		for name, hold := range holders {
+			if owner != "" && name != owner {
+				continue
+			}
+			infos = append(infos, PinInfo{
+				Blk:    buffer.Block(),
+				Owner:  name,
+				Count:  hold.count,
+				Stacks: append([]string(nil), hold.stacks...),
+			})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		a, c := infos[i], infos[j]
+		if a.Blk.FileName() != c.Blk.FileName() {
+			return a.Blk.FileName() < c.Blk.FileName()
+		}
+		if a.Blk.Number() != c.Blk.Number() {
+			return a.Blk.Number() < c.Blk.Number()
+		}
+		return a.Owner < c.Owner
+	})
+	return infos
+}
+
+// OutstandingPins 返回给定持有者还没有unpin的页面
+func (b *BufferManager) OutstandingPins(owner string) []PinInfo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.pinInfos(owner)
+}
+
+// CheckPinLeaks 持有者提交或者关闭时调用，如果还有页面没有unpin就返回ErrPinLeak
+func (b *BufferManager) CheckPinLeaks(owner string) error {
+	infos := b.OutstandingPins(owner)
+	if len(infos) == 0 {
+		return nil
+	}
+	blocks := make([]string, 0, len(infos))
+	for _, info := range infos {
+		blocks = append(blocks, fmt.Sprintf("%s:%d(x%d)", info.Blk.FileName(), info.Blk.Number(), info.Count))
+	}
+	return fmt.Errorf("%w: owner %s holds %s", ErrPinLeak, owner, strings.Join(blocks, ", "))
+}
+
+// DumpPins 列出所有被pin的区块以及持有者，用于诊断
+func (b *BufferManager) DumpPins() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sb := &strings.Builder{}
+	for _, info := range b.pinInfos("") {
+		fmt.Fprintf(sb, "%s:%d owner=%s pins=%d\n", info.Blk.FileName(), info.Blk.Number(), info.Owner, info.Count)
+		for _, stack := range info.Stacks {
+			for _, line := range strings.Split(strings.TrimSpace(stack), "\n") {
+				fmt.Fprintf(sb, "    %s\n", line)
+			}
+		}
+	}
+	return sb.String()
+}
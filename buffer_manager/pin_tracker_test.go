@@ -0,0 +1,68 @@
+package buffer_manager
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	fm "simpleDb/file_manager"
+	lm "simpleDb/log_manager"
+	"strings"
+	"testing"
+)
+
+func TestBufferManager_PinTracking(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "buffertest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "logfile")
+	bufferManager := NewBufferManager(fileManager, logManager, 3, WithPinDebug())
+
+	ctx := WithPinOwner(context.Background(), "tx 1")
+	buf1, err := bufferManager.PinContext(ctx, fm.NewBlockId("testfile", 1))
+	require.Nil(t, err)
+	_, err = bufferManager.PinContext(ctx, fm.NewBlockId("testfile", 1))
+	require.Nil(t, err)
+	buf2, err := bufferManager.Pin(fm.NewBlockId("testfile", 2))
+	require.Nil(t, err)
+
+	infos := bufferManager.OutstandingPins("tx 1")
+	require.Equal(t, 1, len(infos))
+	require.Equal(t, uint64(1), infos[0].Blk.Number())
+	require.Equal(t, 2, infos[0].Count)
+	require.Equal(t, 2, len(infos[0].Stacks))
+
+	dump := bufferManager.DumpPins()
+	require.Contains(t, dump, "testfile:1 owner=tx 1 pins=2")
+	require.Contains(t, dump, "testfile:2 owner="+ANONYMOUS_OWNER+" pins=1")
+	require.Contains(t, dump, "TestBufferManager_PinTracking")
+
+	bufferManager.UnpinAs(buf1, "tx 1")
+	err = bufferManager.CheckPinLeaks("tx 1")
+	require.True(t, errors.Is(err, ErrPinLeak))
+	require.Contains(t, err.Error(), "testfile:1(x1)")
+
+	bufferManager.UnpinAs(buf1, "tx 1")
+	require.Nil(t, bufferManager.CheckPinLeaks("tx 1"))
+	bufferManager.Unpin(buf2)
+	require.Equal(t, "", strings.TrimSpace(bufferManager.DumpPins()))
+	require.Equal(t, uint32(3), bufferManager.Available())
+}
+
+func TestBufferManager_UnpinWrongOwner(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "buffertest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "logfile")
+	bufferManager := NewBufferManager(fileManager, logManager, 3)
+
+	ctx := WithPinOwner(context.Background(), "tx 1")
+	buffer, err := bufferManager.PinContext(ctx, fm.NewBlockId("testfile", 1))
+	require.Nil(t, err)
+
+	// 不是持有者的unpin被拒绝，引用计数和记录都不变
+	require.True(t, errors.Is(bufferManager.UnpinAs(buffer, "tx 2"), ErrPinOwner))
+	require.True(t, errors.Is(bufferManager.Unpin(buffer), ErrPinOwner))
+	require.True(t, buffer.IsPinned())
+	require.Equal(t, 1, len(bufferManager.OutstandingPins("tx 1")))
+
+	require.Nil(t, bufferManager.UnpinAs(buffer, "tx 1"))
+	require.False(t, buffer.IsPinned())
+	require.Equal(t, uint32(3), bufferManager.Available())
+}
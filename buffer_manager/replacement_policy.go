@@ -0,0 +1,381 @@
+package buffer_manager
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+/*
+ReplacementPolicy 决定缓存池满了以后应该置换哪一个页面。BufferManager在持有自己的锁的情况下调用这些接口，
+因此实现不需要再加锁。只有引用计数为0的页面才能被选中置换。
+*/
+type ReplacementPolicy interface {
+	Name() string
+	// Add 把一个空闲的缓存页面交给置换策略管理
+	Add(buffer *Buffer)
+	// Pinned 页面被pin的时候调用，newBlock为true表示页面刚刚读入了新的区块
+	Pinned(buffer *Buffer, newBlock bool)
+	// Unpinned 页面引用计数降为0的时候调用，此后页面可以被置换
+	Unpinned(buffer *Buffer)
+	// Victim 选出一个可以被置换的页面，没有可用的页面时返回nil
+	Victim() *Buffer
+	// Remove 缓存池缩小时把页面从置换策略中移除
+	Remove(buffer *Buffer)
+}
+
+// NaivePolicy 按缓存池的顺序选择第一个没有被pin的页面，这是最初的实现方式
+type NaivePolicy struct {
+	order     map[*Buffer]uint64 // 页面在缓存池中的顺序
+	next      uint64
+	evictable *evictableHeap
+}
+
+func NewNaivePolicy() *NaivePolicy {
+	return &NaivePolicy{
+		order:     make(map[*Buffer]uint64),
+		evictable: newEvictableHeap(),
+	}
+}
+
+func (n *NaivePolicy) Name() string {
+	return "naive"
+}
+
+func (n *NaivePolicy) Add(buffer *Buffer) {
+	n.order[buffer] = n.next
+	n.next++
+	n.evictable.push(buffer, n.order[buffer])
+}
+
+func (n *NaivePolicy) Pinned(buffer *Buffer, _ bool) {
+	n.evictable.remove(buffer)
+}
+
+func (n *NaivePolicy) Unpinned(buffer *Buffer) {
+	n.evictable.push(buffer, n.order[buffer])
+}
+
+func (n *NaivePolicy) Victim() *Buffer {
+	return n.evictable.peek()
+}
+
+func (n *NaivePolicy) Remove(buffer *Buffer) {
+	n.evictable.remove(buffer)
+	delete(n.order, buffer)
+}
+
+// FIFOPolicy 置换最早读入区块的页面，不考虑页面之后被访问的情况
+type FIFOPolicy struct {
+	loaded    map[*Buffer]uint64 // 页面读入区块的时间
+	clock     uint64
+	evictable *evictableHeap
+}
+
+func NewFIFOPolicy() *FIFOPolicy {
+	return &FIFOPolicy{
+		loaded:    make(map[*Buffer]uint64),
+		evictable: newEvictableHeap(),
+	}
+}
+
+func (f *FIFOPolicy) Name() string {
+	return "fifo"
+}
+
+func (f *FIFOPolicy) Add(buffer *Buffer) {
+	f.clock++
+	f.loaded[buffer] = f.clock
+	f.evictable.push(buffer, f.clock)
+}
+
+func (f *FIFOPolicy) Pinned(buffer *Buffer, newBlock bool) {
+	f.evictable.remove(buffer)
+	if newBlock {
+		f.clock++
+		f.loaded[buffer] = f.clock
+	}
+}
+
+func (f *FIFOPolicy) Unpinned(buffer *Buffer) {
+	f.evictable.push(buffer, f.loaded[buffer])
+}
+
+func (f *FIFOPolicy) Victim() *Buffer {
+	return f.evictable.peek()
+}
+
+func (f *FIFOPolicy) Remove(buffer *Buffer) {
+	f.evictable.remove(buffer)
+	delete(f.loaded, buffer)
+}
+
+// LRUPolicy 置换最久没有被使用的页面，只有没被pin的页面才会在链表中，因此选择页面的开销是O(1)
+type LRUPolicy struct {
+	queue    *list.List // 按照unpin的时间排序，队首最久没被使用
+	elements map[*Buffer]*list.Element
+}
+
+func NewLRUPolicy() *LRUPolicy {
+	return &LRUPolicy{
+		queue:    list.New(),
+		elements: make(map[*Buffer]*list.Element),
+	}
+}
+
+func (l *LRUPolicy) Name() string {
+	return "lru"
+}
+
+func (l *LRUPolicy) Add(buffer *Buffer) {
+	l.Unpinned(buffer)
+}
+
+func (l *LRUPolicy) Pinned(buffer *Buffer, _ bool) {
+	if e, ok := l.elements[buffer]; ok {
+		l.queue.Remove(e)
+		delete(l.elements, buffer)
+	}
+}
+
+func (l *LRUPolicy) Unpinned(buffer *Buffer) {
+	if e, ok := l.elements[buffer]; ok {
+		l.queue.MoveToBack(e)
+		return
+	}
+	l.elements[buffer] = l.queue.PushBack(buffer)
+}
+
+func (l *LRUPolicy) Victim() *Buffer {
+	e := l.queue.Front()
+	if e == nil {
+		return nil
+	}
+	return e.Value.(*Buffer)
+}
+
+func (l *LRUPolicy) Remove(buffer *Buffer) {
+	l.Pinned(buffer, false)
+}
+
+/*
+ClockPolicy 时钟算法，页面被访问时设置引用位，指针扫过时清除引用位，置换第一个引用位为0的页面。
+环上只保存没有被pin的页面，页面被pin时从环上摘下，unpin之后放回到指针的前面，指针转一圈时最后才会扫到它，
+因此选择页面时不会扫过被pin的页面
+*/
+type ClockPolicy struct {
+	ring       *list.List // 没有被pin的页面，首尾相连组成时钟
+	elements   map[*Buffer]*list.Element
+	referenced map[*Buffer]bool
+	hand       *list.Element // 下一个要检查的页面，环为空时为nil
+}
+
+func NewClockPolicy() *ClockPolicy {
+	return &ClockPolicy{
+		ring:       list.New(),
+		elements:   make(map[*Buffer]*list.Element),
+		referenced: make(map[*Buffer]bool),
+	}
+}
+
+func (c *ClockPolicy) Name() string {
+	return "clock"
+}
+
+func (c *ClockPolicy) Add(buffer *Buffer) {
+	c.Unpinned(buffer)
+}
+
+func (c *ClockPolicy) Pinned(buffer *Buffer, _ bool) {
+	c.unlink(buffer)
+	c.referenced[buffer] = true
+}
+
+func (c *ClockPolicy) Unpinned(buffer *Buffer) {
+	if _, ok := c.elements[buffer]; ok {
+		return
+	}
+	if c.hand == nil {
+		c.hand = c.ring.PushBack(buffer)
+		c.elements[buffer] = c.hand
+		return
+	}
+	c.elements[buffer] = c.ring.InsertBefore(buffer, c.hand)
+}
+
+// next 环上的下一个页面，链表的末尾接回开头
+func (c *ClockPolicy) next(e *list.Element) *list.Element {
+	if n := e.Next(); n != nil {
+		return n
+	}
+	return c.ring.Front()
+}
+
+func (c *ClockPolicy) Victim() *Buffer {
+	// 最多转两圈：第一圈清除引用位，第二圈一定能找到页面
+	for i := 0; i < 2*c.ring.Len(); i++ {
+		buffer := c.hand.Value.(*Buffer)
+		c.hand = c.next(c.hand)
+		if c.referenced[buffer] {
+			c.referenced[buffer] = false
+			continue
+		}
+		return buffer
+	}
+	return nil
+}
+
+func (c *ClockPolicy) unlink(buffer *Buffer) {
+	e, ok := c.elements[buffer]
+	if !ok {
+		return
+	}
+	if e == c.hand {
+		c.hand = c.next(e)
+	}
+	c.ring.Remove(e)
+	delete(c.elements, buffer)
+	if c.ring.Len() == 0 {
+		c.hand = nil
+	}
+}
+
+func (c *ClockPolicy) Remove(buffer *Buffer) {
+	c.unlink(buffer)
+	delete(c.referenced, buffer)
+}
+
+/*
+LRUKPolicy 记录每个页面最近K次被访问的时间，置换倒数第K次访问时间最早的页面。访问次数不足K次的页面
+被认为距离无穷远，会被优先置换，它们之间按照最早的访问时间选择。页面读入新的区块时访问记录会被清空，
+因此一次顺序扫描读入的页面不会把经常访问的页面挤出去。访问记录只在页面被pin时改变，
+所以页面unpin时就能算出它的优先级，放进evictableHeap中
+*/
+type LRUKPolicy struct {
+	k         int
+	clock     uint64
+	history   map[*Buffer][]uint64 // 最近K次访问的时间，最近的在最后
+	evictable *evictableHeap
+}
+
+// lruKFull 访问满K次的页面优先级加上这一位，排在所有不足K次的页面后面
+const lruKFull = uint64(1) << 63
+
+func NewLRUKPolicy(k int) *LRUKPolicy {
+	if k < 1 {
+		k = 1
+	}
+	return &LRUKPolicy{
+		k:         k,
+		history:   make(map[*Buffer][]uint64),
+		evictable: newEvictableHeap(),
+	}
+}
+
+func (l *LRUKPolicy) Name() string {
+	return "lru-k"
+}
+
+func (l *LRUKPolicy) Add(buffer *Buffer) {
+	l.history[buffer] = nil
+	l.Unpinned(buffer)
+}
+
+func (l *LRUKPolicy) Pinned(buffer *Buffer, newBlock bool) {
+	l.evictable.remove(buffer)
+	l.clock++
+	h := l.history[buffer]
+	if newBlock {
+		h = h[:0]
+	}
+	h = append(h, l.clock)
+	if len(h) > l.k {
+		h = h[len(h)-l.k:]
+	}
+	l.history[buffer] = h
+}
+
+func (l *LRUKPolicy) Unpinned(buffer *Buffer) {
+	h := l.history[buffer]
+	priority := uint64(0) // 从来没有访问过的页面最优先
+	if len(h) > 0 {
+		priority = h[0] // 不足K次时是最早一次访问，满K次时是倒数第K次访问
+	}
+	if len(h) >= l.k {
+		priority |= lruKFull
+	}
+	l.evictable.push(buffer, priority)
+}
+
+func (l *LRUKPolicy) Remove(buffer *Buffer) {
+	delete(l.history, buffer)
+	l.evictable.remove(buffer)
+}
+
+func (l *LRUKPolicy) Victim() *Buffer {
+	return l.evictable.peek()
+}
+
+/*
+evictableHeap 按优先级保存可以被置换的页面，值越小越先被置换。删除采用延迟的方式：只清除标记，
+等到页面出现在堆顶时再真正弹出，这样pin和unpin的开销都是O(log n)，选择页面时不需要扫描整个缓存池。
+*/
+type evictableHeap struct {
+	items    frameItems
+	priority map[*Buffer]uint64 // 当前可以被置换的页面以及它们的优先级
+}
+
+type frameItem struct {
+	buffer   *Buffer
+	priority uint64
+}
+
+type frameItems []frameItem
+
+func (f frameItems) Len() int            { return len(f) }
+func (f frameItems) Less(i, j int) bool  { return f[i].priority < f[j].priority }
+func (f frameItems) Swap(i, j int)       { f[i], f[j] = f[j], f[i] }
+func (f *frameItems) Push(x interface{}) { *f = append(*f, x.(frameItem)) }
+func (f *frameItems) Pop() interface{} {
+	old := *f
+	item := old[len(old)-1]
+	*f = old[:len(old)-1]
+	return item
+}
+
+func newEvictableHeap() *evictableHeap {
+	return &evictableHeap{
+		priority: make(map[*Buffer]uint64),
+	}
+}
+
+func (e *evictableHeap) push(buffer *Buffer, priority uint64) {
+	if p, ok := e.priority[buffer]; ok && p == priority {
+		return
+	}
+	e.priority[buffer] = priority
+	heap.Push(&e.items, frameItem{buffer: buffer, priority: priority})
+	if len(e.items) > 2*len(e.priority)+16 {
+		// 失效的记录太多时重建堆，避免反复pin和unpin让堆无限增长
+		e.items = e.items[:0]
+		for b, p := range e.priority {
+			e.items = append(e.items, frameItem{buffer: b, priority: p})
+		}
+		heap.Init(&e.items)
+	}
+}
+
+func (e *evictableHeap) remove(buffer *Buffer) {
+	delete(e.priority, buffer)
+}
+
+func (e *evictableHeap) peek() *Buffer {
+	for e.items.Len() > 0 {
+		top := e.items[0]
+		if p, ok := e.priority[top.buffer]; ok && p == top.priority {
+			return top.buffer
+		}
+		// 已经失效的记录
+		heap.Pop(&e.items)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package buffer_manager
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"path/filepath"
+	fm "simpleDb/file_manager"
+	lm "simpleDb/log_manager"
+	"testing"
+)
+
+func newFrames(policy ReplacementPolicy, n int) []*Buffer {
+	frames := make([]*Buffer, n)
+	for i := range frames {
+		frames[i] = &Buffer{}
+		policy.Add(frames[i])
+	}
+	return frames
+}
+
+// access 模拟一次pin加unpin
+func access(policy ReplacementPolicy, buffer *Buffer, newBlock bool) {
+	policy.Pinned(buffer, newBlock)
+	policy.Unpinned(buffer)
+}
+
+func TestLRUPolicy_Victim(t *testing.T) {
+	policy := NewLRUPolicy()
+	frames := newFrames(policy, 3)
+	access(policy, frames[1], true)
+	access(policy, frames[0], true)
+	access(policy, frames[2], true)
+	require.Equal(t, frames[1], policy.Victim())
+
+	policy.Pinned(frames[1], false)
+	require.Equal(t, frames[0], policy.Victim())
+	policy.Pinned(frames[0], false)
+	policy.Pinned(frames[2], false)
+	require.Nil(t, policy.Victim())
+}
+
+func TestFIFOPolicy_Victim(t *testing.T) {
+	policy := NewFIFOPolicy()
+	frames := newFrames(policy, 2)
+	access(policy, frames[0], true)
+	access(policy, frames[1], true)
+	access(policy, frames[0], false) // 再次访问不改变顺序
+	require.Equal(t, frames[0], policy.Victim())
+}
+
+func TestClockPolicy_Victim(t *testing.T) {
+	policy := NewClockPolicy()
+	frames := newFrames(policy, 3)
+	for _, frame := range frames {
+		access(policy, frame, true)
+	}
+	// 所有页面的引用位都被设置，转一圈之后选中指针最开始指向的页面
+	require.Equal(t, frames[0], policy.Victim())
+	access(policy, frames[1], false)
+	require.Equal(t, frames[2], policy.Victim())
+
+	// 被pin的页面不在环上
+	policy.Pinned(frames[2], false)
+	policy.Pinned(frames[0], false)
+	require.Equal(t, frames[1], policy.Victim())
+	policy.Pinned(frames[1], false)
+	require.Nil(t, policy.Victim())
+}
+
+func TestLRUKPolicy_Victim(t *testing.T) {
+	policy := NewLRUKPolicy(2)
+	frames := newFrames(policy, 3)
+	access(policy, frames[0], true)
+	access(policy, frames[0], false)
+	access(policy, frames[1], true)
+	access(policy, frames[1], false)
+	access(policy, frames[2], true)
+	// 只被访问过一次的页面优先被置换
+	require.Equal(t, frames[2], policy.Victim())
+	access(policy, frames[2], false)
+	// 都访问了两次，倒数第二次访问最早的是frames[0]
+	require.Equal(t, frames[0], policy.Victim())
+}
+
+func TestBufferManager_LRUPolicy(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "buffertest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "logfile")
+	bufferManager := NewBufferManager(fileManager, logManager, 3, WithReplacementPolicy(NewLRUPolicy()))
+	require.Equal(t, "lru", bufferManager.Policy().Name())
+
+	buffers := make([]*Buffer, 3)
+	for i := range buffers {
+		buffer, err := bufferManager.Pin(fm.NewBlockId("testfile", uint64(i)))
+		require.Nil(t, err)
+		buffers[i] = buffer
+	}
+	bufferManager.Unpin(buffers[1])
+	bufferManager.Unpin(buffers[0])
+	bufferManager.Unpin(buffers[2])
+
+	buffer, err := bufferManager.Pin(fm.NewBlockId("testfile", 3))
+	require.Nil(t, err)
+	require.Equal(t, buffers[1], buffer)
+}
+
+// hitRatio 用给定的置换策略跑一遍访问序列，返回缓存命中率
+func hitRatio(policy ReplacementPolicy, numFrames int, trace []uint64) float64 {
+	frames := newFrames(policy, numFrames)
+	blockOf := make(map[*Buffer]uint64)
+	frameOf := make(map[uint64]*Buffer)
+	for _, frame := range frames {
+		blockOf[frame] = ^uint64(0)
+	}
+
+	hits := 0
+	for _, blk := range trace {
+		if frame, ok := frameOf[blk]; ok {
+			hits++
+			access(policy, frame, false)
+			continue
+		}
+		victim := policy.Victim()
+		delete(frameOf, blockOf[victim])
+		blockOf[victim] = blk
+		frameOf[blk] = victim
+		access(policy, victim, true)
+	}
+	return float64(hits) / float64(len(trace))
+}
+
+func sequentialTrace(numBlocks uint64, length int) []uint64 {
+	trace := make([]uint64, length)
+	for i := range trace {
+		trace[i] = uint64(i) % numBlocks
+	}
+	return trace
+}
+
+// skewedTrace 访问集中在少数热点区块上，同时穿插对冷数据的顺序扫描
+func skewedTrace(numBlocks uint64, length int) []uint64 {
+	r := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(r, 1.2, 1, numBlocks-1)
+	trace := make([]uint64, 0, length)
+	scan := numBlocks
+	for len(trace) < length {
+		if r.Intn(20) == 0 {
+			for i := 0; i < 10 && len(trace) < length; i++ {
+				trace = append(trace, scan)
+				scan++
+			}
+			continue
+		}
+		trace = append(trace, zipf.Uint64())
+	}
+	return trace
+}
+
+func BenchmarkReplacementPolicies(b *testing.B) {
+	policies := []func() ReplacementPolicy{
+		func() ReplacementPolicy { return NewNaivePolicy() },
+		func() ReplacementPolicy { return NewFIFOPolicy() },
+		func() ReplacementPolicy { return NewLRUPolicy() },
+		func() ReplacementPolicy { return NewClockPolicy() },
+		func() ReplacementPolicy { return NewLRUKPolicy(2) },
+	}
+	workloads := map[string][]uint64{
+		"sequential": sequentialTrace(120, 20000),
+		"skewed":     skewedTrace(1000, 20000),
+	}
+
+	for name, trace := range workloads {
+		for _, newPolicy := range policies {
+			b.Run(fmt.Sprintf("%s/%s", name, newPolicy().Name()), func(b *testing.B) {
+				ratio := 0.0
+				for i := 0; i < b.N; i++ {
+					ratio = hitRatio(newPolicy(), 100, trace)
+				}
+				b.ReportMetric(ratio, "hit-ratio")
+			})
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package buffer_manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	fm "simpleDb/file_manager"
+	"time"
+)
+
+var ErrNotEnoughBuffers = errors.New("not enough buffers to reserve")
+var ErrReservationExhausted = errors.New("all reserved buffers are in use")
+
+/*
+Reservation 预留的一组页面，给排序、哈希连接、块嵌套循环连接这些需要同时使用多个页面的算子使用。
+预留的页面不会被其他的Pin占用，通过Reservation.Pin使用，用完后调用Release归还。
+*/
+type Reservation struct {
+	bufferManager *BufferManager
+	owner         string
+	total         uint32
+	pinned        []*Buffer // 通过预留pin的页面，同一个页面可能出现多次
+	released      bool
+}
+
+// Reserve 预留n个页面，没有足够的可用页面时立刻返回ErrNotEnoughBuffers而不是等待，避免饿死其他事务
+func (b *BufferManager) Reserve(n uint32) (*Reservation, error) {
+	return b.ReserveAs(n, ANONYMOUS_OWNER)
+}
+
+// ReserveAs 与Reserve相同，通过预留pin的页面记录在owner名下
+func (b *BufferManager) ReserveAs(n uint32, owner string) (*Reservation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if n == 0 || b.unreserved() < n {
+		return nil, fmt.Errorf("%w: want %d, available %d", ErrNotEnoughBuffers, n, b.unreserved())
+	}
+	b.reserved += n
+	return &Reservation{
+		bufferManager: b,
+		owner:         owner,
+		total:         n,
+	}, nil
+}
+
+// Size 返回预留的页面数
+func (r *Reservation) Size() uint32 {
+	return r.total
+}
+
+// Remaining 返回还可以通过预留pin的页面数
+func (r *Reservation) Remaining() uint32 {
+	b := r.bufferManager
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if r.released {
+		return 0
+	}
+	return r.total - uint32(len(r.pinned))
+}
+
+// Pin 使用预留的页面pin给定的区块
+func (r *Reservation) Pin(blk *fm.BlockId) (*Buffer, error) {
+	ctx, cancel := context.WithTimeout(WithPinOwner(context.Background(), r.owner), MAX_TIME*time.Second)
+	defer cancel()
+
+	b := r.bufferManager
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if r.released || uint32(len(r.pinned)) >= r.total {
+		return nil, ErrReservationExhausted
+	}
+	// 一般情况下预留的页面一定可以直接使用，只有预留的页面同时被别人pin着的时候才需要等待
+	buffer, err := b.waitPin(ctx, blk, r)
+	if err != nil {
+		return nil, err
+	}
+	b.trackPin(buffer, r.owner)
+	r.pinned = append(r.pinned, buffer)
+	b.reserved--
+	return buffer, nil
+}
+
+// PinNew 在给定文件(通常是临时文件)的末尾添加一个区块，并用预留的页面pin它
+func (r *Reservation) PinNew(fileName string) (*Buffer, error) {
+	blk, err := r.bufferManager.fm.Append(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return r.Pin(blk)
+}
+
+// Unpin 释放通过预留pin的页面，页面重新回到预留中
+func (r *Reservation) Unpin(buffer *Buffer) {
+	b := r.bufferManager
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r.unpin(buffer)
+}
+
+func (r *Reservation) unpin(buffer *Buffer) {
+	b := r.bufferManager
+	for i, pinned := range r.pinned {
+		if pinned != buffer {
+			continue
+		}
+		r.pinned = append(r.pinned[:i], r.pinned[i+1:]...)
+		b.unpin(buffer, r.owner)
+		b.reserved++
+		return
+	}
+}
+
+// Release 释放所有通过预留pin的页面并归还预留，重复调用没有效果
+func (r *Reservation) Release() {
+	b := r.bufferManager
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if r.released {
+		return
+	}
+	for len(r.pinned) > 0 {
+		r.unpin(r.pinned[len(r.pinned)-1])
+	}
+	b.reserved -= r.total
+	r.released = true
+	b.cond.Broadcast()
+}
+
+// BestRoot 根据当前可用的页面数，返回不超过可用页面数的size的最小的根，多趟归并排序用来决定每趟的路数
+func (b *BufferManager) BestRoot(size uint32) uint32 {
+	return BestRoot(b.Available(), size)
+}
+
+// BestFactor 根据当前可用的页面数，返回不超过可用页面数的size的最大的因子，块嵌套循环连接用来决定每块的大小
+func (b *BufferManager) BestFactor(size uint32) uint32 {
+	return BestFactor(b.Available(), size)
+}
+
+// BestRoot 留出两个页面给其他用途，返回最小的k，使得k的i次方不小于size并且k不超过剩下的页面数，返回值至少为1
+func BestRoot(available uint32, size uint32) uint32 {
+	avail := int64(available) - 2
+	if avail <= 1 || size <= 1 {
+		return 1
+	}
+	k := int64(math.MaxInt64)
+	i := 1.0
+	for k > avail {
+		i++
+		k = int64(math.Ceil(math.Pow(float64(size), 1/i)))
+	}
+	return uint32(k)
+}
+
+// BestFactor 留出两个页面给其他用途，返回最大的k，使得size能分成大小为k的若干块并且k不超过剩下的页面数，返回值至少为1
+func BestFactor(available uint32, size uint32) uint32 {
+	avail := int64(available) - 2
+	if avail <= 1 || size <= 1 {
+		return 1
+	}
+	k := int64(size)
+	i := 1.0
+	for k > avail {
+		i++
+		k = int64(math.Ceil(float64(size) / i))
+	}
+	return uint32(k)
+}
@@ -0,0 +1,72 @@
+package buffer_manager
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	fm "simpleDb/file_manager"
+	lm "simpleDb/log_manager"
+	"testing"
+	"time"
+)
+
+func TestBufferManager_Reserve(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "buffertest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "logfile")
+	bufferManager := NewBufferManager(fileManager, logManager, 4)
+
+	reservation, err := bufferManager.Reserve(3)
+	require.Nil(t, err)
+	require.Equal(t, uint32(1), bufferManager.Available())
+	_, err = bufferManager.Reserve(2)
+	require.True(t, errors.Is(err, ErrNotEnoughBuffers))
+
+	// 普通的pin只能使用剩下的一个页面
+	buf1, err := bufferManager.Pin(fm.NewBlockId("testfile", 1))
+	require.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = bufferManager.PinContext(ctx, fm.NewBlockId("testfile", 2))
+	require.True(t, errors.Is(err, ErrBufferAbort))
+
+	// 预留的页面可以用来pin临时文件的新区块
+	temps := make([]*Buffer, 0)
+	for i := 0; i < 3; i++ {
+		buffer, err := reservation.PinNew("temp_sort")
+		require.Nil(t, err)
+		require.Equal(t, uint64(i), buffer.Block().Number())
+		temps = append(temps, buffer)
+	}
+	require.Equal(t, uint32(0), reservation.Remaining())
+	_, err = reservation.Pin(fm.NewBlockId("testfile", 3))
+	require.Equal(t, ErrReservationExhausted, err)
+
+	reservation.Unpin(temps[0])
+	require.Equal(t, uint32(1), reservation.Remaining())
+	require.Equal(t, uint32(0), bufferManager.Available())
+
+	reservation.Release()
+	reservation.Release()
+	require.Equal(t, uint32(3), bufferManager.Available())
+	bufferManager.Unpin(buf1)
+	require.Equal(t, uint32(4), bufferManager.Available())
+}
+
+func TestBestRootAndFactor(t *testing.T) {
+	require.Equal(t, uint32(1), BestRoot(3, 100))
+	require.Equal(t, uint32(10), BestRoot(12, 100)) // 10*10 >= 100
+	require.Equal(t, uint32(5), BestRoot(8, 100))   // 5*5*5 >= 100
+	require.Equal(t, uint32(100), BestFactor(200, 100))
+	require.Equal(t, uint32(50), BestFactor(60, 100))
+	require.Equal(t, uint32(34), BestFactor(40, 100))
+	// 没有数据时也至少用一个页面
+	require.Equal(t, uint32(1), BestRoot(12, 0))
+	require.Equal(t, uint32(1), BestFactor(12, 0))
+
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "buffertest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "logfile")
+	bufferManager := NewBufferManager(fileManager, logManager, 12)
+	require.Equal(t, uint32(10), bufferManager.BestRoot(100))
+	require.Equal(t, uint32(10), bufferManager.BestFactor(100))
+}
@@ -0,0 +1,133 @@
+package buffer_manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ShrinkPolicy 缩小缓存池时，没有足够的未被pin页面可以移除时的处理方式
+type ShrinkPolicy int
+
+const (
+	SHRINK_REJECT ShrinkPolicy = iota // 直接返回ErrPoolBusy，缓存池保持不变
+	SHRINK_WAIT                       // 先移除没有被pin的页面，剩下的等它们被unpin后再移除
+)
+
+var ErrPoolBusy = errors.New("not enough unpinned buffers to shrink the pool")
+
+// WithShrinkPolicy 指定缩小缓存池的策略，默认是SHRINK_REJECT
+func WithShrinkPolicy(policy ShrinkPolicy) Option {
+	return func(b *BufferManager) {
+		b.shrinkPolicy = policy
+	}
+}
+
+// Size 返回缓存池中页面的总数
+func (b *BufferManager) Size() uint32 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return uint32(len(b.bufferPool))
+}
+
+// Resize 在线调整缓存池的大小，按照SHRINK_WAIT策略缩小时最多等待MAX_TIME秒
+func (b *BufferManager) Resize(n uint32) error {
+	ctx, cancel := context.WithTimeout(context.Background(), MAX_TIME*time.Second)
+	defer cancel()
+	return b.ResizeContext(ctx, n)
+}
+
+/*
+ResizeContext 与Resize相同，等待被pin的页面的时间由ctx决定。缩小时只会移除没有被pin的页面，脏页会先写回磁盘。
+缩小不是原子的：等待超时或者写回失败时，已经移除的页面不会再加回来，缓存池停留在原大小和n之间，
+返回的错误中会给出已经移除的页面数和当前的大小，调用者可以用Size查询或者再次调用Resize
+*/
+func (b *BufferManager) ResizeContext(ctx context.Context, n uint32) error {
+	if n == 0 {
+		return errors.New("buffer pool size must be positive")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	size := uint32(len(b.bufferPool))
+	if n >= size {
+		b.grow(n - size)
+		return nil
+	}
+
+	toRemove := size - n
+	if b.shrinkPolicy == SHRINK_REJECT && b.unreserved() < toRemove {
+		return fmt.Errorf("%w: want to remove %d, only %d unpinned", ErrPoolBusy, toRemove, b.unreserved())
+	}
+
+	stop := b.wakeOnDone(ctx)
+	defer stop()
+	for {
+		removed, err := b.shrink(toRemove)
+		toRemove -= removed
+		if err != nil {
+			return fmt.Errorf("shrink stopped after removing %d of %d buffers, pool size is %d: %w",
+				size-n-toRemove, size-n, len(b.bufferPool), err)
+		}
+		if toRemove == 0 {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("%w: removed %d of %d buffers, pool size is %d, %d still pinned: %v",
+				ErrPoolBusy, size-n-toRemove, size-n, len(b.bufferPool), toRemove, err)
+		}
+		b.cond.Wait()
+	}
+}
+
+// grow 增加n个空闲页面，调用者需要持有锁
+func (b *BufferManager) grow(n uint32) {
+	for i := uint32(0); i < n; i++ {
+		buffer := NewBuffer(b.fm, b.lm)
+		b.bufferPool = append(b.bufferPool, buffer)
+		b.policy.Add(buffer)
+		b.freeList = append(b.freeList, buffer)
+		b.numAvailable++
+	}
+	if n > 0 {
+		b.cond.Broadcast()
+	}
+}
+
+// shrink 最多移除n个没有被pin也没有被预留的页面，返回实际移除的数量，调用者需要持有锁
+func (b *BufferManager) shrink(n uint32) (uint32, error) {
+	removed := uint32(0)
+	for removed < n && b.unreserved() > 0 {
+		buffer := b.chooseUnpinBuffer()
+		if buffer == nil {
+			break
+		}
+		if err := buffer.Flush(); err != nil {
+			// 页面已经从空闲列表中取出，放回去以保持一致
+			if buffer.Block() == nil {
+				b.freeList = append(b.freeList, buffer)
+			}
+			return removed, err
+		}
+		b.removeBuffer(buffer)
+		removed++
+	}
+	return removed, nil
+}
+
+func (b *BufferManager) removeBuffer(buffer *Buffer) {
+	if blk := buffer.Block(); blk != nil {
+		delete(b.pageTable, keyOf(blk))
+	}
+	b.policy.Remove(buffer)
+	for i, frame := range b.bufferPool {
+		if frame == buffer {
+			b.bufferPool = append(b.bufferPool[:i], b.bufferPool[i+1:]...)
+			break
+		}
+	}
+	b.numAvailable--
+}
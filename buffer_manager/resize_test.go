@@ -0,0 +1,94 @@
+package buffer_manager
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	fm "simpleDb/file_manager"
+	lm "simpleDb/log_manager"
+	"testing"
+	"time"
+)
+
+func TestBufferManager_Resize(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "buffertest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "logfile")
+	bufferManager := NewBufferManager(fileManager, logManager, 2, WithReplacementPolicy(NewClockPolicy()))
+
+	buf1, _ := bufferManager.Pin(fm.NewBlockId("testfile", 1))
+	buf2, _ := bufferManager.Pin(fm.NewBlockId("testfile", 2))
+	buf2.Contents().SetInt(80, 222)
+	buf2.SetModified(1, 0)
+	bufferManager.Unpin(buf2)
+
+	require.Nil(t, bufferManager.Resize(4))
+	require.Equal(t, uint32(4), bufferManager.Size())
+	require.Equal(t, uint32(3), bufferManager.Available())
+
+	// 3个没有被pin的页面都会被移除，只留下被pin的页面
+	err := bufferManager.Resize(1)
+	require.Nil(t, err)
+	require.Equal(t, uint32(1), bufferManager.Size())
+	require.Equal(t, uint32(0), bufferManager.Available())
+	require.Nil(t, bufferManager.findExistingBuffer(fm.NewBlockId("testfile", 2)))
+	require.Equal(t, buf1, bufferManager.findExistingBuffer(fm.NewBlockId("testfile", 1)))
+
+	// 被移除的脏页已经写回磁盘
+	page := fm.NewPageBySize(400)
+	fileManager.Read(fm.NewBlockId("testfile", 2), page)
+	require.Equal(t, uint64(222), page.GetInt(80))
+
+	require.Nil(t, bufferManager.Resize(2))
+	err = bufferManager.Resize(0)
+	require.NotNil(t, err)
+	buf3, err := bufferManager.Pin(fm.NewBlockId("testfile", 3))
+	require.Nil(t, err)
+	// 默认策略下被pin的页面不能移除
+	err = bufferManager.Resize(1)
+	require.True(t, errors.Is(err, ErrPoolBusy))
+	require.Equal(t, uint32(2), bufferManager.Size())
+	bufferManager.Unpin(buf3)
+	bufferManager.Unpin(buf1)
+	require.Equal(t, uint32(2), bufferManager.Available())
+}
+
+func TestBufferManager_ResizeWait(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "buffertest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "logfile")
+	bufferManager := NewBufferManager(fileManager, logManager, 3, WithShrinkPolicy(SHRINK_WAIT))
+
+	buf1, _ := bufferManager.Pin(fm.NewBlockId("testfile", 1))
+	buf2, _ := bufferManager.Pin(fm.NewBlockId("testfile", 2))
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		bufferManager.Unpin(buf2)
+	}()
+
+	require.Nil(t, bufferManager.Resize(1))
+	require.Equal(t, uint32(1), bufferManager.Size())
+	require.Equal(t, uint32(0), bufferManager.Available())
+	require.Equal(t, buf1, bufferManager.findExistingBuffer(fm.NewBlockId("testfile", 1)))
+}
+
+func TestBufferManager_ResizeWaitTimeout(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "buffertest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "logfile")
+	bufferManager := NewBufferManager(fileManager, logManager, 3, WithShrinkPolicy(SHRINK_WAIT))
+
+	buf1, _ := bufferManager.Pin(fm.NewBlockId("testfile", 1))
+	buf2, _ := bufferManager.Pin(fm.NewBlockId("testfile", 2))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// 超时之前只移除了1个没有被pin的页面，缓存池停留在部分缩小的状态
+	err := bufferManager.ResizeContext(ctx, 1)
+	require.True(t, errors.Is(err, ErrPoolBusy))
+	require.Contains(t, err.Error(), "removed 1 of 2 buffers, pool size is 2")
+	require.Equal(t, uint32(2), bufferManager.Size())
+
+	bufferManager.Unpin(buf2)
+	require.Nil(t, bufferManager.Resize(1))
+	require.Equal(t, uint32(1), bufferManager.Size())
+	bufferManager.Unpin(buf1)
+}
@@ -0,0 +1,98 @@
+package buffer_manager
+
+import "time"
+
+// FileStats 某个文件的缓存使用情况
+type FileStats struct {
+	Pins           uint64
+	Hits           uint64
+	Misses         uint64
+	Evictions      uint64 // 这个文件的区块被置换出缓存的次数
+	DirtyEvictions uint64 // 置换时需要先写回磁盘的次数
+}
+
+// Stats 缓存池统计信息的快照，Pinned和Dirty是取快照时的状态，其余的是从上一次ResetStats开始的累计值
+type Stats struct {
+	FileStats
+	Waits            uint64        // Pin时没有可用页面需要等待的次数
+	WaitTime         time.Duration // Pin等待的总时间
+	BackgroundWrites uint64        // 后台写回的页面数
+	Pinned           uint32        // 当前被pin的页面数
+	Dirty            uint32        // 当前被修改还没写回磁盘的页面数
+	Files            map[string]FileStats
+}
+
+// HitRatio 返回缓存命中率，没有pin过时返回0
+func (s *FileStats) HitRatio() float64 {
+	if s.Pins == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Pins)
+}
+
+func (b *BufferManager) fileStatsOf(fileName string) *FileStats {
+	fs, ok := b.fileStats[fileName]
+	if !ok {
+		fs = &FileStats{}
+		b.fileStats[fileName] = fs
+	}
+	return fs
+}
+
+func (b *BufferManager) recordPin(fileName string, hit bool) {
+	fs := b.fileStatsOf(fileName)
+	b.stats.Pins++
+	fs.Pins++
+	if hit {
+		b.stats.Hits++
+		fs.Hits++
+	} else {
+		b.stats.Misses++
+		fs.Misses++
+	}
+}
+
+func (b *BufferManager) recordEviction(fileName string, dirty bool) {
+	fs := b.fileStatsOf(fileName)
+	b.stats.Evictions++
+	fs.Evictions++
+	if dirty {
+		b.stats.DirtyEvictions++
+		fs.DirtyEvictions++
+	}
+}
+
+func (b *BufferManager) recordWait(d time.Duration) {
+	b.stats.Waits++
+	b.stats.WaitTime += d
+}
+
+// Stats 返回当前统计信息的快照
+func (b *BufferManager) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snapshot := b.stats
+	snapshot.Files = make(map[string]FileStats, len(b.fileStats))
+	for name, fs := range b.fileStats {
+		snapshot.Files[name] = *fs
+	}
+	for _, buffer := range b.bufferPool {
+		if buffer.IsPinned() {
+			snapshot.Pinned++
+		}
+		if buffer.IsModified() {
+			snapshot.Dirty++
+		}
+	}
+	return snapshot
+}
+
+// ResetStats 清空累计的统计信息，方便压测时只统计某一段时间
+func (b *BufferManager) ResetStats() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.stats = Stats{}
+	b.fileStats = make(map[string]*FileStats)
+}
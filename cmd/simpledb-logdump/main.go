@@ -0,0 +1,264 @@
+/*
+simpledb-logdump 以只读方式打开数据库目录，从头到尾遍历预写日志，打印每条日志的编号、所在位置、类别以及解析后的字段。
+
+用法:
+
+	simpledb-logdump -dir tx_test [-log logfile] [-blocksize 400] [-tx 3] [-type setint] [-block test_file:1] [-json]
+*/
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	fm "simpleDb/file_manager"
+	lm "simpleDb/log_manager"
+	tx "simpleDb/transaction_manager"
+	"strconv"
+	"strings"
+)
+
+// dumpedRecord 一条解析后的日志，同时用于文本输出和json输出
+type dumpedRecord struct {
+	LSN       uint64      `json:"lsn"` // 日志编号，由日志的位置算出，和恢复时使用的编号相同
+	LogBlock  uint64      `json:"log_block"`
+	LogOffset uint64      `json:"log_offset"`
+	Type      string      `json:"type"`
+	TxNum     *uint64     `json:"tx,omitempty"`
+	PrevLSN   *uint64     `json:"prev_lsn,omitempty"`  // 同一个事务的上一条日志
+	UndoNext  *uint64     `json:"undo_next,omitempty"` // 补偿日志之后下一条需要撤销的日志
+	File      string      `json:"file,omitempty"`
+	Block     *uint64     `json:"block,omitempty"`
+	Offset    *uint64     `json:"offset,omitempty"`
+	OldValue  interface{} `json:"old_value,omitempty"`
+	NewValue  interface{} `json:"new_value,omitempty"`
+	Text      string      `json:"text"`
+	Error     string      `json:"error,omitempty"` // 日志无法解析时的原因
+
+	op tx.RECORD_TYPE
+}
+
+type filter struct {
+	txNum     *uint64
+	recType   *tx.RECORD_TYPE
+	blockFile string
+	blockNum  *uint64
+}
+
+// match 无法解析的日志不知道属于哪个事务和区块，总是输出
+func (f *filter) match(rec *dumpedRecord) bool {
+	if rec.Error != "" {
+		return true
+	}
+	if f.txNum != nil && (rec.TxNum == nil || *rec.TxNum != *f.txNum) {
+		return false
+	}
+	if f.recType != nil && rec.op != *f.recType {
+		return false
+	}
+	if f.blockFile != "" {
+		if rec.File != f.blockFile {
+			return false
+		}
+		if f.blockNum != nil && (rec.Block == nil || *rec.Block != *f.blockNum) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseBlock 解析 "文件名" 或者 "文件名:区块号" 格式的区块过滤条件
+func parseBlock(s string, f *filter) error {
+	idx := strings.LastIndex(s, ":")
+	if idx < 0 {
+		f.blockFile = s
+		return nil
+	}
+	num, err := strconv.ParseUint(s[idx+1:], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid block %q: %v", s, err)
+	}
+	f.blockFile = s[:idx]
+	f.blockNum = &num
+	return nil
+}
+
+func decode(bytes []byte) (*dumpedRecord, error) {
+	logRecord, err := tx.DecodeLogRecord(nil, bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &dumpedRecord{
+		Type: logRecord.Op().String(),
+		Text: logRecord.ToString(),
+		op:   logRecord.Op(),
+	}
+	if logRecord.Op() != tx.CHECKPOINT && logRecord.Op() != tx.NQCKPT {
+		txNum := logRecord.TxNumber()
+		rec.TxNum = &txNum
+	}
+
+	if r, ok := logRecord.(tx.TxRecord); ok {
+		prevLSN := r.PrevLSN()
+		rec.PrevLSN = &prevLSN
+	}
+
+	var blk *fm.BlockId
+	var offset uint64
+	switch r := logRecord.(type) {
+	case *tx.CompensationRecord:
+		undoNext := r.UndoNext()
+		rec.UndoNext = &undoNext
+		// 补偿日志只记录撤销之后页面上的内容，没有旧值，按照十六进制显示
+		if r.Block() != nil {
+			blk, offset, rec.NewValue = r.Block(), r.Offset(), hex.EncodeToString(r.Image())
+		}
+	case *tx.SetIntRecord:
+		blk, offset, rec.OldValue, rec.NewValue = r.Block(), r.Offset(), r.OldValue(), r.NewValue()
+	case *tx.SetStringRecord:
+		blk, offset, rec.OldValue, rec.NewValue = r.Block(), r.Offset(), r.OldValue(), r.NewValue()
+	case *tx.SetBytesRecord:
+		// 字节数据按照十六进制显示
+		blk, offset = r.Block(), r.Offset()
+		rec.OldValue, rec.NewValue = hex.EncodeToString(r.OldValue()), hex.EncodeToString(r.NewValue())
+	}
+	if blk != nil {
+		blkNum := blk.Number()
+		rec.File = blk.FileName()
+		rec.Block = &blkNum
+		rec.Offset = &offset
+	}
+	return rec, nil
+}
+
+// readLog 从日志末尾往前读出全部日志，返回时按照写入的先后顺序排列。无法解析的日志记下错误原因，继续读后面的日志
+func readLog(fileManager *fm.FileManager, logFile string) ([]*dumpedRecord, error) {
+	size, err := fileManager.Size(logFile)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	iter := lm.NewLogIterator(fileManager, fm.NewBlockId(logFile, size-1))
+	if iter == nil {
+		return nil, errors.New("can not read log file " + logFile)
+	}
+
+	records := make([]*dumpedRecord, 0)
+	for iter.HasNext() {
+		bytes := iter.Next()
+		rec, err := decode(bytes)
+		if err != nil {
+			rec = &dumpedRecord{Type: "INVALID", Error: err.Error()}
+		}
+		blk, pos := iter.Location()
+		rec.LogBlock = blk.Number()
+		rec.LogOffset = pos
+		rec.LSN = iter.LSN()
+		records = append(records, rec)
+	}
+
+	// 迭代器是从新往旧读的，这里反转过来
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	return records, nil
+}
+
+func printText(w io.Writer, rec *dumpedRecord) {
+	location := fmt.Sprintf("%d:%d", rec.LogBlock, rec.LogOffset)
+	fields := ""
+	if rec.TxNum != nil {
+		fields = fmt.Sprintf("tx=%d", *rec.TxNum)
+	}
+	if rec.PrevLSN != nil {
+		fields += fmt.Sprintf(" prev=%d", *rec.PrevLSN)
+	}
+	if rec.Error != "" {
+		fields += "error: " + rec.Error
+	}
+	if rec.UndoNext != nil {
+		fields += fmt.Sprintf(" undo_next=%d", *rec.UndoNext)
+	}
+	if rec.Block != nil {
+		fields += fmt.Sprintf(" blk=%s:%d offset=%d", rec.File, *rec.Block, *rec.Offset)
+		if rec.OldValue != nil {
+			fields += fmt.Sprintf(" old=%v", rec.OldValue)
+		}
+		fields += fmt.Sprintf(" new=%v", rec.NewValue)
+	}
+	fmt.Fprintf(w, "%-6d %-10s %-10s %s\n", rec.LSN, location, rec.Type, strings.TrimSpace(fields))
+}
+
+func run(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("simpledb-logdump", flag.ContinueOnError)
+	dir := flags.String("dir", "", "database directory")
+	logFile := flags.String("log", "logfile", "log file name inside the database directory")
+	blockSize := flags.Uint64("blocksize", 400, "block size the database was created with")
+	txNum := flags.Int64("tx", -1, "only show records of the given transaction")
+	recType := flags.String("type", "", "only show records of the given type, e.g. SETINT")
+	block := flags.String("block", "", "only show records touching the given block, file or file:num")
+	asJson := flags.Bool("json", false, "print one json object per line")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return errors.New("-dir is required")
+	}
+
+	f := &filter{}
+	if *txNum >= 0 {
+		n := uint64(*txNum)
+		f.txNum = &n
+	}
+	if *recType != "" {
+		t, err := tx.ParseRecordType(*recType)
+		if err != nil {
+			return err
+		}
+		f.recType = &t
+	}
+	if *block != "" {
+		if err := parseBlock(*block, f); err != nil {
+			return err
+		}
+	}
+
+	fileManager, err := fm.NewReadOnlyFileManager(*dir, *blockSize)
+	if err != nil {
+		return err
+	}
+	records, err := readLog(fileManager, *logFile)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(stdout)
+	for _, rec := range records {
+		if !f.match(rec) {
+			continue
+		}
+		if *asJson {
+			if err := encoder.Encode(rec); err != nil {
+				return err
+			}
+		} else {
+			printText(stdout, rec)
+		}
+	}
+	return nil
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "simpledb-logdump:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	fm "simpleDb/file_manager"
+	lm "simpleDb/log_manager"
+	tx "simpleDb/transaction_manager"
+	"strings"
+	"testing"
+)
+
+func writeTestLog(t *testing.T) (string, []uint64) {
+	dir := filepath.Join(t.TempDir(), "db")
+	fileManager, err := fm.NewFileManager(dir, 400)
+	require.Nil(t, err)
+	logManager, err := lm.NewLogManager(fileManager, "logfile")
+	require.Nil(t, err)
+
+	blk1 := fm.NewBlockId("test_file", 1)
+	blk2 := fm.NewBlockId("test_file", 2)
+	p := fm.NewPageBySize(16)
+	p.SetInt(0, uint64(tx.START))
+	p.SetInt(8, 1)
+	startLSN, _ := tx.NewStartRecord(logManager, p).WriteToLog()
+	setIntLSN, _ := tx.WriteSetIntLog(logManager, 1, startLSN, blk1, 80, 7, 8)
+	setStringLSN, _ := tx.WriteSetStringLog(logManager, 1, setIntLSN, blk2, 40, "one", "two")
+	// 撤销SETSTRING之后页面上是旧值"one"
+	image := fm.NewPageBySize(16)
+	image.SetString(0, "one")
+	clrLSN, _ := tx.WriteCompensationLog(logManager, 1, setStringLSN, setIntLSN, blk2, 40, image.GetRawBytes(0, image.MaxLengthForString("one")))
+	tx.WriteCommitRecord(logManager, 1)
+	tx.WriteCheckPoint(logManager)
+	tx.WriteSetBytesLog(logManager, 2, 0, blk1, 120, []byte{1, 2}, []byte{3, 4})
+	require.Nil(t, logManager.Flush())
+	return dir, []uint64{startLSN, setIntLSN, setStringLSN, clrLSN}
+}
+
+func TestDumpAll(t *testing.T) {
+	dir, lsns := writeTestLog(t)
+	out := &bytes.Buffer{}
+	require.Nil(t, run([]string{"-dir", dir}, out))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Equal(t, 7, len(lines))
+	require.True(t, strings.HasPrefix(lines[0], fmt.Sprintf("%d ", lsns[0])))
+	require.Contains(t, lines[0], "START")
+	require.Contains(t, lines[1], "SETINT")
+	require.Contains(t, lines[1], fmt.Sprintf("tx=1 prev=%d blk=test_file:1 offset=80 old=7 new=8", lsns[0]))
+	require.Contains(t, lines[3], "CLR")
+	require.Contains(t, lines[3], fmt.Sprintf("prev=%d undo_next=%d blk=test_file:2 offset=40 new=03000000000000006f6e65", lsns[2], lsns[1]))
+	require.Contains(t, lines[5], "CHECKPOINT")
+	require.Contains(t, lines[6], "SETBYTES")
+	require.Contains(t, lines[6], "tx=2 prev=0 blk=test_file:1 offset=120 old=0102 new=0304")
+}
+
+func TestDumpFilterJson(t *testing.T) {
+	dir, lsns := writeTestLog(t)
+	out := &bytes.Buffer{}
+	require.Nil(t, run([]string{"-dir", dir, "-tx", "1", "-type", "setstring", "-block", "test_file:2", "-json"}, out))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Equal(t, 1, len(lines))
+	rec := make(map[string]interface{})
+	require.Nil(t, json.Unmarshal([]byte(lines[0]), &rec))
+	require.Equal(t, "SETSTRING", rec["type"])
+	require.Equal(t, "one", rec["old_value"])
+	require.Equal(t, "two", rec["new_value"])
+	require.Equal(t, float64(lsns[2]), rec["lsn"])
+	require.Equal(t, float64(lsns[1]), rec["prev_lsn"])
+
+	out.Reset()
+	require.Nil(t, run([]string{"-dir", dir, "-block", "test_file:3"}, out))
+	require.Equal(t, "", out.String())
+}
+
+func TestDumpInvalidRecord(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	fileManager, err := fm.NewFileManager(dir, 400)
+	require.Nil(t, err)
+	logManager, err := lm.NewLogManager(fileManager, "logfile")
+	require.Nil(t, err)
+
+	tx.WriteCommitRecord(logManager, 1)
+	// 类别是SETSTRING，文件名的长度字段是垃圾数据
+	garbage := fm.NewPageBySize(40)
+	garbage.SetInt(0, uint64(tx.SETSTRING))
+	garbage.SetInt(24, 1<<60)
+	badLSN, err := logManager.Append(garbage.GetRawBytes(0, 40))
+	require.Nil(t, err)
+	// 截断的COMMIT
+	truncated := fm.NewPageBySize(12)
+	truncated.SetInt(0, uint64(tx.COMMIT))
+	truncatedLSN, err := logManager.Append(truncated.GetRawBytes(0, 12))
+	require.Nil(t, err)
+	tx.WriteCommitRecord(logManager, 2)
+	require.Nil(t, logManager.Flush())
+
+	out := &bytes.Buffer{}
+	require.Nil(t, run([]string{"-dir", dir, "-tx", "2"}, out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Equal(t, 3, len(lines))
+	require.True(t, strings.HasPrefix(lines[0], fmt.Sprintf("%d ", badLSN)))
+	require.Contains(t, lines[0], "INVALID")
+	require.Contains(t, lines[0], "malformed log record: SETSTRING")
+	require.True(t, strings.HasPrefix(lines[1], fmt.Sprintf("%d ", truncatedLSN)))
+	require.Contains(t, lines[1], "malformed log record: COMMIT")
+	require.Contains(t, lines[2], "COMMIT     tx=2")
+}
+
+func TestDumpMissingDir(t *testing.T) {
+	err := run([]string{"-dir", filepath.Join(t.TempDir(), "missing")}, &bytes.Buffer{})
+	require.NotNil(t, err)
+}
@@ -1,6 +1,8 @@
 package file_manager
 
 import (
+	"errors"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -12,10 +14,22 @@ type FileManager struct {
 	dbDirectory string
 	blockSize   uint64
 	isNew       bool
+	readOnly    bool // 只读模式下不会创建或修改任何文件
 	openFiles   map[string]*os.File
+	writeHook   WriteHook
 	mu          sync.Mutex
 }
 
+// WriteHook 在每次写入区块(包括Append)之前调用，返回错误时放弃这次写入，测试中用来模拟在任意一次写入时崩溃
+type WriteHook func(blk *BlockId) error
+
+// SetWriteHook 设置写入钩子，传nil取消
+func (f *FileManager) SetWriteHook(hook WriteHook) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writeHook = hook
+}
+
 func NewFileManager(dbDirectory string, blockSize uint64) (*FileManager, error) {
 	fileManager := FileManager{
 		dbDirectory: dbDirectory,
@@ -39,7 +53,7 @@ func NewFileManager(dbDirectory string, blockSize uint64) (*FileManager, error)
 				name := info.Name()
 				if strings.HasPrefix(name, "temp") {
 					// 删除临时文件
-					os.Remove(filepath.Join(path, name))
+					os.Remove(path)
 				}
 			}
 			return nil
@@ -52,9 +66,36 @@ func NewFileManager(dbDirectory string, blockSize uint64) (*FileManager, error)
 	return &fileManager, nil
 }
 
+var ErrReadOnly = errors.New("file manager is read only")
+
+// NewReadOnlyFileManager 以只读方式打开已经存在的数据库目录，不会创建目录，也不会删除临时文件，
+// 主要给日志查看之类的诊断工具使用
+func NewReadOnlyFileManager(dbDirectory string, blockSize uint64) (*FileManager, error) {
+	info, err := os.Stat(dbDirectory)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New(dbDirectory + " is not a directory")
+	}
+
+	return &FileManager{
+		dbDirectory: dbDirectory,
+		blockSize:   blockSize,
+		readOnly:    true,
+		openFiles:   make(map[string]*os.File),
+	}, nil
+}
+
 func (f *FileManager) getFile(fileName string) (*os.File, error) {
 	path := filepath.Join(f.dbDirectory, fileName)
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	var file *os.File
+	var err error
+	if f.readOnly {
+		file, err = os.Open(path)
+	} else {
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -75,6 +116,13 @@ func (f *FileManager) Read(blk *BlockId, p *Page) (int, error) {
 	defer file.Close()
 
 	count, err := file.ReadAt(p.contents(), int64(blk.Number()*f.blockSize))
+	if err == io.EOF {
+		// 区块超出了文件末尾，没有读到的部分当作0，页面中不能留下之前的数据
+		contents := p.contents()
+		for i := count; i < len(contents); i++ {
+			contents[i] = 0
+		}
+	}
 	if err != nil {
 		return 0, err
 	}
@@ -86,6 +134,15 @@ func (f *FileManager) Write(blk *BlockId, p *Page) (int, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	if f.readOnly {
+		return 0, ErrReadOnly
+	}
+	if f.writeHook != nil {
+		if err := f.writeHook(blk); err != nil {
+			return 0, err
+		}
+	}
+
 	file, err := f.getFile(blk.FileName())
 	if err != nil {
 		return 0, err
@@ -117,12 +174,23 @@ func (f *FileManager) Size(fileName string) (uint64, error) {
 
 // Append 不是很明白这个返回
 func (f *FileManager) Append(fileName string) (*BlockId, error) {
+	if f.readOnly {
+		return &BlockId{}, ErrReadOnly
+	}
 	newBlockNum, err := f.Size(fileName)
 	if err != nil {
 		return &BlockId{}, err
 	}
 
 	blk := NewBlockId(fileName, newBlockNum)
+	f.mu.Lock()
+	hook := f.writeHook
+	f.mu.Unlock()
+	if hook != nil {
+		if err := hook(blk); err != nil {
+			return &BlockId{}, err
+		}
+	}
 	file, err := f.getFile(blk.FileName())
 	if err != nil {
 		return &BlockId{}, err
@@ -138,10 +206,85 @@ func (f *FileManager) Append(fileName string) (*BlockId, error) {
 	return blk, nil
 }
 
+/*
+WriteFileAtomic 用data整体替换文件的内容，先写入临时文件并同步到磁盘，再重命名为目标文件并同步所在的目录，
+任何时候崩溃，文件要么是原来的内容要么是新的内容。用于主记录这种不按区块组织的小文件
+*/
+func (f *FileManager) WriteFileAtomic(fileName string, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.readOnly {
+		return ErrReadOnly
+	}
+	if f.writeHook != nil {
+		if err := f.writeHook(NewBlockId(fileName, 0)); err != nil {
+			return err
+		}
+	}
+	// 临时文件以temp开头，崩溃之后留下的临时文件在下次打开数据库时删除
+	tempPath := filepath.Join(f.dbDirectory, "temp_"+fileName)
+	file, err := os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	path := filepath.Join(f.dbDirectory, fileName)
+	if err := os.Rename(tempPath, path); err != nil {
+		return err
+	}
+	// 重命名修改的是目录项，目录也要同步到磁盘，否则崩溃之后可能还是原来的文件
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir 把目录的修改（创建、删除、重命名文件）同步到磁盘
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// ReadFile 读出整个文件的内容，和WriteFileAtomic配合使用
+func (f *FileManager) ReadFile(fileName string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return os.ReadFile(filepath.Join(f.dbDirectory, fileName))
+}
+
+// Path 返回文件在磁盘上的绝对路径
+func (f *FileManager) Path(fileName string) string {
+	path := filepath.Join(f.dbDirectory, fileName)
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
 func (f *FileManager) IsNew() bool {
 	return f.isNew
 }
 
+func (f *FileManager) IsReadOnly() bool {
+	return f.readOnly
+}
+
 func (f *FileManager) BlockSize() uint64 {
 	return f.blockSize
 }
@@ -1,7 +1,11 @@
 package file_manager
 
 import (
+	"errors"
 	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -28,3 +32,88 @@ func TestFileManager_Append(t *testing.T) {
 
 	require.Equal(t, s, p2.GetString(pos1))
 }
+
+func TestNewReadOnlyFileManager(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "readonly")
+	fileManager, _ := NewFileManager(dir, 400)
+	blk := NewBlockId("testFile", 0)
+	p := NewPageBySize(fileManager.BlockSize())
+	p.SetInt(8, 99)
+	fileManager.Write(blk, p)
+
+	readOnly, err := NewReadOnlyFileManager(dir, 400)
+	require.Nil(t, err)
+	p2 := NewPageBySize(readOnly.BlockSize())
+	_, err = readOnly.Read(blk, p2)
+	require.Nil(t, err)
+	require.Equal(t, uint64(99), p2.GetInt(8))
+
+	_, err = readOnly.Write(blk, p2)
+	require.Equal(t, ErrReadOnly, err)
+	_, err = readOnly.Append("testFile")
+	require.Equal(t, ErrReadOnly, err)
+
+	_, err = NewReadOnlyFileManager(filepath.Join(dir, "missing"), 400)
+	require.NotNil(t, err)
+}
+
+func TestFileManager_WriteHook(t *testing.T) {
+	fileManager, _ := NewFileManager(filepath.Join(t.TempDir(), "hook"), 400)
+	crash := errors.New("crash")
+	writes := 0
+	fileManager.SetWriteHook(func(blk *BlockId) error {
+		writes++
+		if writes > 1 {
+			return crash
+		}
+		return nil
+	})
+
+	blk, err := fileManager.Append("testFile")
+	require.Nil(t, err)
+	p := NewPageBySize(fileManager.BlockSize())
+	_, err = fileManager.Write(blk, p)
+	require.Equal(t, crash, err)
+	_, err = fileManager.Append("testFile")
+	require.Equal(t, crash, err)
+
+	fileManager.SetWriteHook(nil)
+	_, err = fileManager.Write(blk, p)
+	require.Nil(t, err)
+}
+
+func TestFileManager_ReadPastEnd(t *testing.T) {
+	fileManager, _ := NewFileManager(filepath.Join(t.TempDir(), "eof"), 400)
+	p := NewPageBySize(fileManager.BlockSize())
+	p.SetInt(80, 42)
+	_, err := fileManager.Read(NewBlockId("testFile", 3), p)
+	require.Equal(t, io.EOF, err)
+	// 读文件末尾之外的区块得到全0，不会留下页面中原来的数据
+	require.Equal(t, uint64(0), p.GetInt(80))
+}
+
+func TestFileManager_WriteFileAtomic(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "atomic")
+	fileManager, _ := NewFileManager(dir, 400)
+	require.Nil(t, fileManager.WriteFileAtomic("master", []byte("first")))
+	require.Nil(t, fileManager.WriteFileAtomic("master", []byte("second")))
+	data, err := fileManager.ReadFile("master")
+	require.Nil(t, err)
+	require.Equal(t, []byte("second"), data)
+	require.Nil(t, syncDir(dir))
+	require.NotNil(t, syncDir(filepath.Join(dir, "missing")))
+
+	// 写入失败时保留原来的内容
+	fileManager.SetWriteHook(func(blk *BlockId) error { return errors.New("crash") })
+	require.NotNil(t, fileManager.WriteFileAtomic("master", []byte("third")))
+	data, err = fileManager.ReadFile("master")
+	require.Nil(t, err)
+	require.Equal(t, []byte("second"), data)
+
+	// 崩溃留下的临时文件在重新打开时被删除
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "temp_master"), []byte("torn"), 0644))
+	_, err = NewFileManager(dir, 400)
+	require.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, "temp_master"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -1,6 +1,9 @@
 package file_manager
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"math"
+)
 
 type Page struct {
 	buffer []byte // 对应内存中的一块数据
@@ -50,6 +53,46 @@ func (p *Page) SetBytes(offset uint64, bytes []byte) {
 	copy(p.buffer[offset+8:], bytes)
 }
 
+// GetRawBytes 读出从offset开始的length个字节，和GetBytes不同，数据前面没有长度
+func (p *Page) GetRawBytes(offset uint64, length uint64) []byte {
+	newBuffer := make([]byte, length)
+	copy(newBuffer, p.buffer[offset:offset+length])
+	return newBuffer
+}
+
+// SetRawBytes 把bytes原样写入offset开始的位置，不写入长度
+func (p *Page) SetRawBytes(offset uint64, bytes []byte) {
+	copy(p.buffer[offset:offset+uint64(len(bytes))], bytes)
+}
+
+func (p *Page) GetInt32(offset uint64) int32 {
+	return int32(binary.LittleEndian.Uint32(p.buffer[offset : offset+4]))
+}
+
+func (p *Page) SetInt32(offset uint64, val int32) {
+	binary.LittleEndian.PutUint32(p.buffer[offset:offset+4], uint32(val))
+}
+
+// GetBool 布尔值占一个字节，非0为true
+func (p *Page) GetBool(offset uint64) bool {
+	return p.buffer[offset] != 0
+}
+
+func (p *Page) SetBool(offset uint64, val bool) {
+	p.buffer[offset] = 0
+	if val {
+		p.buffer[offset] = 1
+	}
+}
+
+func (p *Page) GetFloat64(offset uint64) float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(p.buffer[offset : offset+8]))
+}
+
+func (p *Page) SetFloat64(offset uint64, val float64) {
+	binary.LittleEndian.PutUint64(p.buffer[offset:offset+8], math.Float64bits(val))
+}
+
 func (p *Page) GetString(offset uint64) string {
 	bytes := p.GetBytes(offset)
 	return string(bytes)
@@ -66,6 +109,11 @@ func (p Page) MaxLengthForString(s string) uint64 {
 	return uint64(8 + len(bs))
 }
 
+// Size 页面的字节数
+func (p *Page) Size() uint64 {
+	return uint64(len(p.buffer))
+}
+
 func (p *Page) contents() []byte {
 	return p.buffer
 }
@@ -47,3 +47,20 @@ func TestGetContents(t *testing.T) {
 	contents := p.contents()
 	require.Equal(t, bs, contents)
 }
+
+func TestPage_RawBytesAndTypedValues(t *testing.T) {
+	p := NewPageBySize(64)
+	p.SetRawBytes(10, []byte{1, 2, 3})
+	require.Equal(t, []byte{1, 2, 3}, p.GetRawBytes(10, 3))
+	// 没有长度前缀，前后的字节不受影响
+	require.Equal(t, []byte{0, 1, 2, 3, 0}, p.GetRawBytes(9, 5))
+
+	p.SetInt32(20, -7)
+	require.Equal(t, int32(-7), p.GetInt32(20))
+	p.SetBool(24, true)
+	require.True(t, p.GetBool(24))
+	p.SetBool(24, false)
+	require.False(t, p.GetBool(24))
+	p.SetFloat64(30, 3.25)
+	require.Equal(t, 3.25, p.GetFloat64(30))
+}
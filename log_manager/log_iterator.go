@@ -15,6 +15,7 @@ type LogIterator struct {
 	p           *fm.Page
 	currentPos  uint64
 	boundary    uint64
+	recordPos   uint64 // 最近一次Next返回的日志在区块中的偏移
 }
 
 func NewLogIterator(fileManager *fm.FileManager, blk *fm.BlockId) *LogIterator {
@@ -42,6 +43,10 @@ func (it *LogIterator) moveToBlock(blk *fm.BlockId) error {
 
 	// 获取日志的起始地址
 	it.boundary = it.p.GetInt(0)
+	if it.boundary == 0 {
+		// 区块添加之后还没有写入偏移，里面没有日志
+		it.boundary = it.fileManager.BlockSize()
+	}
 	it.currentPos = it.boundary
 
 	return nil
@@ -56,6 +61,7 @@ func (it *LogIterator) Next() []byte {
 	}
 
 	record := it.p.GetBytes(it.currentPos)
+	it.recordPos = it.currentPos
 	it.currentPos += UINT64_LEN + uint64(len(record))
 
 	return record
@@ -67,3 +73,93 @@ func (it *LogIterator) HasNext() bool {
 	*/
 	return it.currentPos < it.fileManager.BlockSize() || it.blk.Number() > 0
 }
+
+// Location 返回最近一次Next读出的日志所在的区块以及区块内的偏移
+func (it *LogIterator) Location() (*fm.BlockId, uint64) {
+	return it.blk, it.recordPos
+}
+
+// LSN 返回最近一次Next读出的日志的编号
+func (it *LogIterator) LSN() uint64 {
+	return LSNOf(it.fileManager.BlockSize(), it.blk.Number(), it.recordPos)
+}
+
+/*
+ForwardLogIterator 按照日志写入的顺序从旧到新遍历日志，用于恢复时的redo。区块内的日志是倒着存放的，
+所以每读入一个区块先找出所有日志的偏移，再按照从大到小的顺序返回
+*/
+type ForwardLogIterator struct {
+	fileManager *fm.FileManager
+	blk         *fm.BlockId
+	lastBlk     uint64 // 遍历到这个区块为止
+	p           *fm.Page
+	positions   []uint64 // 当前区块中还没有返回的日志的偏移，按照从旧到新的顺序
+	recordPos   uint64
+}
+
+// NewForwardLogIterator 从blk中偏移为pos的日志开始(包含这条日志)遍历，pos为区块大小时从区块中最早的日志开始
+func NewForwardLogIterator(fileManager *fm.FileManager, blk *fm.BlockId, pos uint64, lastBlk uint64) *ForwardLogIterator {
+	it := &ForwardLogIterator{
+		fileManager: fileManager,
+		blk:         blk,
+		lastBlk:     lastBlk,
+		p:           fm.NewPageBySize(fileManager.BlockSize()),
+	}
+	if err := it.moveToBlock(blk, pos); err != nil {
+		return nil
+	}
+	return it
+}
+
+func (it *ForwardLogIterator) moveToBlock(blk *fm.BlockId, limit uint64) error {
+	if _, err := it.fileManager.Read(blk, it.p); err != nil {
+		return err
+	}
+	it.positions = it.positions[:0]
+	boundary := it.p.GetInt(0)
+	if boundary == 0 {
+		// 区块添加之后还没有写入偏移，里面没有日志
+		boundary = it.fileManager.BlockSize()
+	}
+	for pos := boundary; pos < it.fileManager.BlockSize(); {
+		if pos <= limit {
+			it.positions = append(it.positions, pos)
+		}
+		pos += UINT64_LEN + uint64(len(it.p.GetBytes(pos)))
+	}
+	// 偏移越大的日志越早写入
+	for i, j := 0, len(it.positions)-1; i < j; i, j = i+1, j-1 {
+		it.positions[i], it.positions[j] = it.positions[j], it.positions[i]
+	}
+	return nil
+}
+
+func (it *ForwardLogIterator) HasNext() bool {
+	// 跳过没有日志的区块
+	for len(it.positions) == 0 && it.blk.Number() < it.lastBlk {
+		it.blk = fm.NewBlockId(it.blk.FileName(), it.blk.Number()+1)
+		if err := it.moveToBlock(it.blk, it.fileManager.BlockSize()); err != nil {
+			return false
+		}
+	}
+	return len(it.positions) > 0
+}
+
+func (it *ForwardLogIterator) Next() []byte {
+	if !it.HasNext() {
+		return nil
+	}
+	it.recordPos = it.positions[0]
+	it.positions = it.positions[1:]
+	return it.p.GetBytes(it.recordPos)
+}
+
+// Location 返回最近一次Next读出的日志所在的区块以及区块内的偏移
+func (it *ForwardLogIterator) Location() (*fm.BlockId, uint64) {
+	return it.blk, it.recordPos
+}
+
+// LSN 返回最近一次Next读出的日志的编号
+func (it *ForwardLogIterator) LSN() uint64 {
+	return LSNOf(it.fileManager.BlockSize(), it.blk.Number(), it.recordPos)
+}
@@ -1,6 +1,7 @@
 package log_manager
 
 import (
+	"fmt"
 	fm "simpleDb/file_manager"
 	"sync"
 )
@@ -9,12 +10,25 @@ const (
 	UINT64_LEN = 8
 )
 
+/*
+LSNOf 日志编号由日志的位置决定: 区块号*区块大小+(区块大小-偏移)。日志在区块内从后往前写，
+越新的日志偏移越小，所以编号随写入顺序递增，并且重启之后不变。编号0表示没有日志
+*/
+func LSNOf(blockSize uint64, blkNum uint64, pos uint64) uint64 {
+	return blkNum*blockSize + (blockSize - pos)
+}
+
+// LocationOf 根据日志编号计算日志所在的区块号和区块内的偏移
+func LocationOf(blockSize uint64, lsn uint64) (uint64, uint64) {
+	return lsn / blockSize, blockSize - lsn%blockSize
+}
+
 type LogManager struct {
 	fileManager  *fm.FileManager
 	logFile      string      // 日志文件的名称
 	logPage      *fm.Page    // 存储日志的缓冲区
 	currentBlk   *fm.BlockId // 日志当前写入的区块号
-	latestLsn    uint64      // 当前最新的日志编号
+	latestLsn    uint64      // 当前最新的日志编号，由日志的位置决定
 	lastSavedLsn uint64      // 上一次写入磁盘的日志编号
 	mu           sync.Mutex
 }
@@ -63,30 +77,50 @@ func NewLogManager(fileManager *fm.FileManager, logFile string) (*LogManager, er
 		// 文件已经存在，先把末尾的日志内容读入内存，如果当前对应区块还有空间，新的日志就写入当前区块
 		logManager.currentBlk = fm.NewBlockId(logManager.logFile, logSize-1)
 		fileManager.Read(logManager.currentBlk, logManager.logPage)
+		if logManager.logPage.GetInt(0) == 0 {
+			// 添加区块之后还没来得及写入偏移就崩溃了，当作空区块重新写入
+			logManager.logPage.SetInt(0, fileManager.BlockSize())
+			if _, err := fileManager.Write(logManager.currentBlk, logManager.logPage); err != nil {
+				return nil, err
+			}
+		}
+		// 磁盘上已有的日志都已经写入了
+		logManager.latestLsn = LSNOf(fileManager.BlockSize(), logSize-1, logManager.logPage.GetInt(0))
+		logManager.lastSavedLsn = logManager.latestLsn
 	}
 
 	return &logManager, nil
 }
 
 func (lm *LogManager) FlushByLSN(lsn uint64) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
 	// 把给定编号之气的日志全部写入磁盘
 	/*
 		当我们写入给定编号的日志的时候，接口会把当前日志处于同一区块的日志写入磁盘，
 		假设现在写入的日志编号为65，如果66，67，68也处于同一个区块，那么他们也会被写入磁盘
 	*/
 	if lsn > lm.lastSavedLsn {
-		err := lm.Flush()
+		err := lm.flush()
 		if err != nil {
 			return err
 		}
 
-		lm.lastSavedLsn = lsn
+		lm.lastSavedLsn = lm.latestLsn
 	}
 	return nil
 }
 
 func (lm *LogManager) Flush() error {
-	// 将给定缓冲区的数据写入磁盘
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	return lm.flush()
+}
+
+func (lm *LogManager) flush() error {
+	// 将给定缓冲区的数据写入磁盘，调用者需要持有锁
 	_, err := lm.fileManager.Write(lm.currentBlk, lm.logPage)
 	if err != nil {
 		return err
@@ -101,20 +135,24 @@ func (lm *LogManager) Append(logRecord []byte) (uint64, error) {
 
 	boundary := lm.logPage.GetInt(0) // 从头部获取可写入的偏移
 	recordSize := uint64(len(logRecord))
+	if recordSize > lm.maxRecordSize() {
+		return lm.latestLsn, fmt.Errorf("log record of %d bytes exceeds max size %d", recordSize, lm.maxRecordSize())
+	}
 	bytesNeed := recordSize + UINT64_LEN
 	var err error
 	if int(boundary-bytesNeed) < int(UINT64_LEN) {
 		// 当前没有足够的空间，现将缓冲区数据写入磁盘
-		err = lm.Flush()
+		err = lm.flush()
 		if err != nil {
 			return lm.latestLsn, err
 		}
 
 		// 分配新的空间用于写入新数据
-		lm.currentBlk, err = lm.appendNewBlock()
+		blk, err := lm.appendNewBlock()
 		if err != nil {
-			return lm.latestLsn, nil
+			return lm.latestLsn, err
 		}
+		lm.currentBlk = blk
 
 		boundary = lm.logPage.GetInt(0) // 获得当前可写入的偏移
 	}
@@ -122,11 +160,87 @@ func (lm *LogManager) Append(logRecord []byte) (uint64, error) {
 	recordPosition := boundary - bytesNeed
 	lm.logPage.SetBytes(recordPosition, logRecord)
 	lm.logPage.SetInt(0, recordPosition)
-	lm.latestLsn += 1
+	lm.latestLsn = LSNOf(lm.fileManager.BlockSize(), lm.currentBlk.Number(), recordPosition)
 	return lm.latestLsn, nil
 }
 
 func (lm *LogManager) Iterator() *LogIterator {
-	lm.Flush()
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	lm.flush()
 	return NewLogIterator(lm.fileManager, lm.currentBlk)
 }
+
+// ForwardIterator 从最早的日志开始按照写入的顺序遍历
+func (lm *LogManager) ForwardIterator() *ForwardLogIterator {
+	return lm.ForwardIteratorFrom(fm.NewBlockId(lm.logFile, 0), lm.fileManager.BlockSize())
+}
+
+/*
+ReadAt 根据日志编号直接读出一条日志，不需要从日志末尾开始遍历。日志编号就是日志的位置，
+日志在当前区块时从内存中读取，否则只读取日志所在的一个区块
+*/
+func (lm *LogManager) ReadAt(lsn uint64) ([]byte, error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if lsn == 0 || lsn > lm.latestLsn {
+		return nil, fmt.Errorf("log record %d does not exist", lsn)
+	}
+	blockSize := lm.fileManager.BlockSize()
+	blkNum, pos := LocationOf(blockSize, lsn)
+	p := lm.logPage
+	if blkNum != lm.currentBlk.Number() {
+		p = fm.NewPageBySize(blockSize)
+		if _, err := lm.fileManager.Read(fm.NewBlockId(lm.logFile, blkNum), p); err != nil {
+			return nil, err
+		}
+	}
+	if pos < p.GetInt(0) || pos+UINT64_LEN > blockSize {
+		return nil, fmt.Errorf("log record %d does not exist", lsn)
+	}
+	return p.GetBytes(pos), nil
+}
+
+// ForwardIteratorFromLSN 从给定编号的日志开始(包含这条日志)按照写入的顺序遍历，编号0表示从最早的日志开始
+func (lm *LogManager) ForwardIteratorFromLSN(lsn uint64) *ForwardLogIterator {
+	blkNum, pos := LocationOf(lm.fileManager.BlockSize(), lsn)
+	return lm.ForwardIteratorFrom(fm.NewBlockId(lm.logFile, blkNum), pos)
+}
+
+// ForwardIteratorFrom 从给定位置的日志开始(包含这条日志)按照写入的顺序遍历，位置可以由LogIterator.Location获得
+func (lm *LogManager) ForwardIteratorFrom(blk *fm.BlockId, pos uint64) *ForwardLogIterator {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	lm.flush()
+	return NewForwardLogIterator(lm.fileManager, blk, pos, lm.currentBlk.Number())
+}
+
+// LatestLSN 返回最近写入的日志编号
+func (lm *LogManager) LatestLSN() uint64 {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.latestLsn
+}
+
+// LogFile 返回日志文件的名称
+func (lm *LogManager) LogFile() string {
+	return lm.logFile
+}
+
+// LogPath 返回日志文件的绝对路径，打开同一个日志文件的日志管理器属于同一个数据库
+func (lm *LogManager) LogPath() string {
+	return lm.fileManager.Path(lm.logFile)
+}
+
+// MaxRecordSize 返回一条日志最多可以有多少字节，日志不能跨区块存放
+func (lm *LogManager) MaxRecordSize() uint64 {
+	return lm.maxRecordSize()
+}
+
+func (lm *LogManager) maxRecordSize() uint64 {
+	// 区块头部的偏移和日志的长度各占8字节
+	return lm.fileManager.BlockSize() - 2*UINT64_LEN
+}
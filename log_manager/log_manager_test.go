@@ -3,6 +3,8 @@ package log_manager
 import (
 	"fmt"
 	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
 	fm "simpleDb/file_manager"
 	"testing"
 )
@@ -26,6 +28,8 @@ func createRecords(lm *LogManager, start uint64, end uint64) {
 }
 
 func TestLogManager_Append(t *testing.T) {
+	// 测试从空日志开始，之前运行留下的日志会影响遍历的结果
+	os.Remove(filepath.Join("logtest", "logfile"))
 	fileManager, _ := fm.NewFileManager("logtest", 400)
 	logManager, err := NewLogManager(fileManager, "logfile")
 	require.Nil(t, err)
@@ -66,3 +70,165 @@ func TestLogManager_Append(t *testing.T) {
 	}
 
 }
+
+func TestLogIterator_Location(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "logtest"), 400)
+	logManager, err := NewLogManager(fileManager, "logfile")
+	require.Nil(t, err)
+
+	createRecords(logManager, 1, 35)
+	iter := logManager.Iterator()
+	lastBlk := uint64(0)
+	for iter.HasNext() {
+		rec := iter.Next()
+		blk, pos := iter.Location()
+		lastBlk = blk.Number()
+
+		p := fm.NewPageBySize(fileManager.BlockSize())
+		fileManager.Read(blk, p)
+		require.Equal(t, rec, p.GetBytes(pos))
+	}
+	require.Equal(t, uint64(0), lastBlk)
+}
+
+func TestForwardLogIterator(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "logtest"), 400)
+	logManager, err := NewLogManager(fileManager, "logfile")
+	require.Nil(t, err)
+
+	createRecords(logManager, 1, 35)
+	iter := logManager.ForwardIterator()
+	recNum := uint64(1)
+	var midBlk *fm.BlockId
+	var midPos uint64
+	for iter.HasNext() {
+		p := fm.NewPageByBytes(iter.Next())
+		require.Equal(t, fmt.Sprintf("record%d", recNum), p.GetString(0))
+		if recNum == 20 {
+			midBlk, midPos = iter.Location()
+		}
+		recNum += 1
+	}
+	require.Equal(t, uint64(36), recNum)
+
+	// 从中间的一条日志开始遍历
+	iter = logManager.ForwardIteratorFrom(midBlk, midPos)
+	recNum = uint64(20)
+	for iter.HasNext() {
+		p := fm.NewPageByBytes(iter.Next())
+		require.Equal(t, fmt.Sprintf("record%d", recNum), p.GetString(0))
+		recNum += 1
+	}
+	require.Equal(t, uint64(36), recNum)
+}
+
+func TestLogManager_LSN(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logtest")
+	fileManager, _ := fm.NewFileManager(dir, 400)
+	logManager, err := NewLogManager(fileManager, "logfile")
+	require.Nil(t, err)
+
+	// 日志编号随写入顺序递增，并且可以从编号算出日志的位置
+	last := uint64(0)
+	for i := uint64(1); i <= 35; i++ {
+		lsn, err := logManager.Append(makeRecords(fmt.Sprintf("record%d", i), i))
+		require.Nil(t, err)
+		require.Greater(t, lsn, last)
+		last = lsn
+	}
+	require.Nil(t, logManager.Flush())
+	blkNum, pos := LocationOf(400, last)
+	p := fm.NewPageBySize(400)
+	fileManager.Read(fm.NewBlockId("logfile", blkNum), p)
+	require.Equal(t, "record35", fm.NewPageByBytes(p.GetBytes(pos)).GetString(0))
+
+	iter := logManager.Iterator()
+	iter.Next()
+	require.Equal(t, last, iter.LSN())
+
+	// 重启之后编号从磁盘上的日志继续
+	logManager, err = NewLogManager(fileManager, "logfile")
+	require.Nil(t, err)
+	require.Equal(t, last, logManager.LatestLSN())
+	lsn, err := logManager.Append(makeRecords("record36", 36))
+	require.Nil(t, err)
+	require.Greater(t, lsn, last)
+}
+
+func TestLogManager_RecordSize(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "logtest"), 400)
+	logManager, err := NewLogManager(fileManager, "logfile")
+	require.Nil(t, err)
+
+	require.Equal(t, uint64(384), logManager.MaxRecordSize())
+	_, err = logManager.Append(make([]byte, 385))
+	require.NotNil(t, err)
+	// 最大的日志刚好占满一个区块
+	first, err := logManager.Append(make([]byte, 384))
+	require.Nil(t, err)
+	second, err := logManager.Append(make([]byte, 384))
+	require.Nil(t, err)
+
+	iter := logManager.ForwardIteratorFromLSN(second)
+	require.True(t, iter.HasNext())
+	iter.Next()
+	require.Equal(t, second, iter.LSN())
+	require.False(t, iter.HasNext())
+
+	iter = logManager.ForwardIteratorFromLSN(0)
+	iter.Next()
+	require.Equal(t, first, iter.LSN())
+}
+
+func TestLogManager_ReadAt(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "logtest"), 400)
+	logManager, err := NewLogManager(fileManager, "logfile")
+	require.Nil(t, err)
+
+	lsns := make([]uint64, 0)
+	for i := uint64(1); i <= 35; i++ {
+		lsn, err := logManager.Append(makeRecords(fmt.Sprintf("record%d", i), i))
+		require.Nil(t, err)
+		lsns = append(lsns, lsn)
+	}
+	// 已经写入磁盘的区块和还在内存中的当前区块都能直接读到
+	for i, lsn := range lsns {
+		rec, err := logManager.ReadAt(lsn)
+		require.Nil(t, err)
+		require.Equal(t, fmt.Sprintf("record%d", i+1), fm.NewPageByBytes(rec).GetString(0))
+	}
+
+	_, err = logManager.ReadAt(0)
+	require.NotNil(t, err)
+	_, err = logManager.ReadAt(lsns[34] + 1)
+	require.NotNil(t, err)
+}
+
+func TestLogManager_Master(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logtest")
+	fileManager, _ := fm.NewFileManager(dir, 400)
+	logManager, err := NewLogManager(fileManager, "logfile")
+	require.Nil(t, err)
+
+	_, ok := logManager.LastCheckpoint()
+	require.False(t, ok)
+	createRecords(logManager, 1, 10)
+	lsn, err := logManager.Append(makeRecords("checkpoint", 11))
+	require.Nil(t, err)
+	require.Nil(t, logManager.SaveCheckpoint(lsn))
+
+	// 重启之后从主记录找到检查点并直接读出
+	logManager, err = NewLogManager(fileManager, "logfile")
+	require.Nil(t, err)
+	saved, ok := logManager.LastCheckpoint()
+	require.True(t, ok)
+	require.Equal(t, lsn, saved)
+	rec, err := logManager.ReadAt(saved)
+	require.Nil(t, err)
+	require.Equal(t, "checkpoint", fm.NewPageByBytes(rec).GetString(0))
+
+	// 损坏的主记录被忽略
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "logfile.master"), []byte("garbage garbage!"), 0644))
+	_, ok = logManager.LastCheckpoint()
+	require.False(t, ok)
+}
@@ -0,0 +1,37 @@
+package log_manager
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// masterFile 主记录文件的名称，和日志文件放在同一个目录中
+func (lm *LogManager) masterFile() string {
+	return lm.logFile + ".master"
+}
+
+/*
+SaveCheckpoint 把最近一个完整检查点的日志编号写入主记录文件，重启时直接从这里找到检查点，不需要从日志末尾往前找。
+写入之前先把检查点之前的日志写入磁盘，主记录不会指向磁盘上还不存在的日志
+*/
+func (lm *LogManager) SaveCheckpoint(lsn uint64) error {
+	if err := lm.FlushByLSN(lsn); err != nil {
+		return err
+	}
+	data := make([]byte, 2*UINT64_LEN)
+	binary.LittleEndian.PutUint64(data, lsn)
+	binary.LittleEndian.PutUint64(data[UINT64_LEN:], uint64(crc32.ChecksumIEEE(data[:UINT64_LEN])))
+	return lm.fileManager.WriteFileAtomic(lm.masterFile(), data)
+}
+
+// LastCheckpoint 返回主记录中的检查点编号，没有主记录或者主记录损坏时ok为false
+func (lm *LogManager) LastCheckpoint() (lsn uint64, ok bool) {
+	data, err := lm.fileManager.ReadFile(lm.masterFile())
+	if err != nil || len(data) != 2*UINT64_LEN {
+		return 0, false
+	}
+	if binary.LittleEndian.Uint64(data[UINT64_LEN:]) != uint64(crc32.ChecksumIEEE(data[:UINT64_LEN])) {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(data), true
+}
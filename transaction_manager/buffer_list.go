@@ -1,8 +1,10 @@
 package transaction_manager
 
 import (
+	"context"
 	bm "simpleDb/buffer_manager"
 	fm "simpleDb/file_manager"
+	"time"
 )
 
 /**
@@ -13,13 +15,15 @@ type BufferList struct {
 	buffers       map[*fm.BlockId]*bm.Buffer
 	bufferManager *bm.BufferManager
 	pins          []*fm.BlockId
+	owner         string // 在缓存管理器中记录pin的持有者
 }
 
-func NewBufferList(bufferManager *bm.BufferManager) *BufferList {
+func NewBufferList(bufferManager *bm.BufferManager, owner string) *BufferList {
 	return &BufferList{
 		buffers:       make(map[*fm.BlockId]*bm.Buffer),
 		bufferManager: bufferManager,
 		pins:          make([]*fm.BlockId, 0),
+		owner:         owner,
 	}
 }
 
@@ -30,7 +34,9 @@ func (b *BufferList) GetBuffer(bkl *fm.BlockId) *bm.Buffer {
 
 func (b *BufferList) Pin(blk *fm.BlockId) error {
 	// 一旦一个内存页被pin后，将其加入到map中进行追踪管理
-	buffer, err := b.bufferManager.Pin(blk)
+	ctx, cancel := context.WithTimeout(bm.WithPinOwner(context.Background(), b.owner), bm.MAX_TIME*time.Second)
+	defer cancel()
+	buffer, err := b.bufferManager.PinContext(ctx, blk)
 	if err != nil {
 		return err
 	}
@@ -45,21 +51,32 @@ func (b *BufferList) Unpin(blk *fm.BlockId) {
 		return
 	}
 
-	b.bufferManager.Unpin(buffer)
+	b.bufferManager.UnpinAs(buffer, b.owner)
 	for i, pinnedBlock := range b.pins {
 		if pinnedBlock == blk {
 			b.pins = append(b.pins[:i], b.pins[i+1:]...)
 			break
 		}
 	}
+	// 同一个区块可能被pin了多次，最后一次unpin之后才不再追踪
+	for _, pinnedBlock := range b.pins {
+		if pinnedBlock == blk {
+			return
+		}
+	}
 	delete(b.buffers, blk)
 }
 
 func (b *BufferList) UnpinAll() {
 	for _, blk := range b.pins {
 		buffer := b.buffers[blk]
-		b.bufferManager.Unpin(buffer)
+		b.bufferManager.UnpinAs(buffer, b.owner)
 	}
 	b.buffers = make(map[*fm.BlockId]*bm.Buffer)
 	b.pins = make([]*fm.BlockId, 0)
 }
+
+// CheckLeaks 检查缓存管理器中是否还有记录在这个持有者名下的pin
+func (b *BufferList) CheckLeaks() error {
+	return b.bufferManager.CheckPinLeaks(b.owner)
+}
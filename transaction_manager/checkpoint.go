@@ -0,0 +1,150 @@
+package transaction_manager
+
+import (
+	bm "simpleDb/buffer_manager"
+	lm "simpleDb/log_manager"
+	"sync"
+	"time"
+)
+
+/*
+Checkpoint 写入一个非静止检查点，不需要等待正在执行的事务结束。先在活跃事务表的锁内读取最新的日志编号和活跃事务，
+再收集缓存池的脏页表，之后写入的日志由恢复时的分析阶段处理。返回检查点最后一条日志的编号，这个编号同时写入主记录
+*/
+func Checkpoint(logManager *lm.LogManager, bufferManager *bm.BufferManager) (uint64, error) {
+	beginLSN, txs := databaseOf(logManager).activeTxs(logManager)
+	pages := bufferManager.DirtyPages()
+	lsn, err := WriteNQCheckPoint(logManager, beginLSN, txs, pages)
+	if err != nil {
+		return 0, err
+	}
+	// 检查点写完之后才更新主记录，主记录总是指向一个完整的检查点
+	if err := logManager.SaveCheckpoint(lsn); err != nil {
+		return 0, err
+	}
+	return lsn, nil
+}
+
+// CheckpointConfig 检查点的触发条件，两个条件满足一个就写入检查点，为0的条件不生效
+type CheckpointConfig struct {
+	Interval     time.Duration // 距离上一个检查点的时间
+	LogBytes     uint64        // 上一个检查点之后写入的日志量
+	PollInterval time.Duration // 检查触发条件的间隔
+}
+
+func DefaultCheckpointConfig() CheckpointConfig {
+	return CheckpointConfig{
+		Interval:     time.Minute,
+		LogBytes:     1 << 20,
+		PollInterval: 100 * time.Millisecond,
+	}
+}
+
+// CheckpointScheduler 在后台按照时间或者日志量定期写入非静止检查点
+type CheckpointScheduler struct {
+	logManager    *lm.LogManager
+	bufferManager *bm.BufferManager
+	config        CheckpointConfig
+	lastTime      time.Time
+	lastLSN       uint64 // 上一个检查点开始时的日志编号，日志编号就是日志的位置，相减得到日志量
+	checkpoints   int
+	lastErr       error
+	stop          chan struct{}
+	done          chan struct{}
+	mu            sync.Mutex
+}
+
+func NewCheckpointScheduler(logManager *lm.LogManager, bufferManager *bm.BufferManager, config CheckpointConfig) *CheckpointScheduler {
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultCheckpointConfig().PollInterval
+	}
+	return &CheckpointScheduler{
+		logManager:    logManager,
+		bufferManager: bufferManager,
+		config:        config,
+		lastTime:      time.Now(),
+		lastLSN:       logManager.LatestLSN(),
+	}
+}
+
+// Start 启动后台协程，重复调用没有效果
+func (s *CheckpointScheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.run(s.stop, s.done)
+}
+
+// Stop 停止后台协程，等待正在写入的检查点结束
+func (s *CheckpointScheduler) Stop() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop = nil
+	s.done = nil
+	s.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+func (s *CheckpointScheduler) run(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(s.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.RunOnce()
+		}
+	}
+}
+
+// RunOnce 检查触发条件，满足时写入一个检查点，返回是否写入了检查点
+func (s *CheckpointScheduler) RunOnce() (bool, error) {
+	s.mu.Lock()
+	due := s.config.Interval > 0 && time.Since(s.lastTime) >= s.config.Interval
+	latest := s.logManager.LatestLSN()
+	if s.config.LogBytes > 0 && latest-s.lastLSN >= s.config.LogBytes {
+		due = true
+	}
+	s.mu.Unlock()
+	if !due {
+		return false, nil
+	}
+
+	_, err := Checkpoint(s.logManager, s.bufferManager)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr = err
+	if err != nil {
+		return false, err
+	}
+	s.lastTime = time.Now()
+	s.lastLSN = latest
+	s.checkpoints++
+	return true, nil
+}
+
+// Checkpoints 返回已经写入的检查点个数
+func (s *CheckpointScheduler) Checkpoints() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checkpoints
+}
+
+// Err 返回最近一次写入检查点的错误
+func (s *CheckpointScheduler) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
@@ -9,6 +9,15 @@ import (
 type CheckPointRecord struct {
 }
 
+func init() {
+	mustRegisterLogRecord(CHECKPOINT, LogRecordHandler{
+		Name: "CHECKPOINT",
+		Decode: func(_ *lg.LogManager, _ *fm.Page) (LogRecordInterface, error) {
+			return NewCheckPointRecord(), nil
+		},
+	})
+}
+
 func NewCheckPointRecord() *CheckPointRecord {
 	return &CheckPointRecord{}
 }
@@ -21,10 +30,6 @@ func (c *CheckPointRecord) TxNumber() uint64 {
 	return math.MaxUint64 // 没有对应的事务id
 }
 
-func (c *CheckPointRecord) Undo(tx TransactionInterface) {
-
-}
-
 func (c *CheckPointRecord) ToString() string {
 	return "<CHECKPOINT>"
 }
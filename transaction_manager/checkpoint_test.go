@@ -0,0 +1,176 @@
+package transaction_manager
+
+import (
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	bm "simpleDb/buffer_manager"
+	fm "simpleDb/file_manager"
+	lm "simpleDb/log_manager"
+	"testing"
+	"time"
+)
+
+// lastNQCheckPoint 从日志末尾往前找到最近一个非静止检查点的全部日志
+func lastNQCheckPoint(logManager *lm.LogManager) []*NQCheckPointRecord {
+	parts := make([]*NQCheckPointRecord, 0)
+	iterator := logManager.Iterator()
+	for iterator.HasNext() {
+		rec, err := DecodeLogRecord(nil, iterator.Next())
+		if err != nil {
+			return nil
+		}
+		if ckpt, ok := rec.(*NQCheckPointRecord); ok {
+			if len(parts) > 0 && ckpt.Final() {
+				break
+			}
+			parts = append(parts, ckpt)
+		}
+	}
+	return parts
+}
+
+func TestCheckpoint_ActiveTxsAndDirtyPages(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "checkpoint")
+	fileManager, logManager, bufferManager := openTestDb(t, dir)
+	blk1 := fm.NewBlockId("test_file", 1)
+	blk2 := fm.NewBlockId("test_file", 2)
+	blk3 := fm.NewBlockId("test_file", 3)
+	blk4 := fm.NewBlockId("test_file", 4)
+
+	tx1 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx1.Pin(blk1))
+	require.Nil(t, tx1.SetInt(blk1, 80, 1, true))
+	tx2 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx2.Pin(blk2))
+	require.Nil(t, tx2.SetInt(blk2, 80, 2, true))
+	tx2.Commit()
+
+	// tx1还在执行，tx2已经提交但是页面还没有写回磁盘
+	_, err := Checkpoint(logManager, bufferManager)
+	require.Nil(t, err)
+	parts := lastNQCheckPoint(logManager)
+	require.Equal(t, 1, len(parts))
+	require.True(t, parts[0].Final())
+	require.Equal(t, map[uint64]uint64{uint64(tx1.txNum): tx1.recoveryManager.LastLSN()}, parts[0].ActiveTxs())
+	pages := make(map[uint64]bool)
+	for _, page := range parts[0].DirtyPages() {
+		pages[page.Blk.Number()] = true
+	}
+	require.Equal(t, map[uint64]bool{1: true, 2: true}, pages)
+
+	// 检查点之后tx1继续修改，tx3修改并提交，然后崩溃
+	require.Nil(t, tx1.Pin(blk3))
+	require.Nil(t, tx1.SetInt(blk3, 80, 1, true))
+	tx3 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx3.Pin(blk4))
+	require.Nil(t, tx3.SetInt(blk4, 80, 3, true))
+	tx3.Commit()
+
+	fileManager, logManager, bufferManager = openTestDb(t, dir)
+	NewTransaction(fileManager, logManager, bufferManager).Recover()
+	require.Equal(t, uint64(0), readDisk(t, fileManager, blk1).GetInt(80))
+	require.Equal(t, uint64(2), readDisk(t, fileManager, blk2).GetInt(80))
+	require.Equal(t, uint64(0), readDisk(t, fileManager, blk3).GetInt(80))
+	require.Equal(t, uint64(3), readDisk(t, fileManager, blk4).GetInt(80))
+}
+
+func TestCheckpoint_SplitRecords(t *testing.T) {
+	fileManager, err := fm.NewFileManager(filepath.Join(t.TempDir(), "checkpoint"), 400)
+	require.Nil(t, err)
+	logManager, err := lm.NewLogManager(fileManager, "logfile")
+	require.Nil(t, err)
+
+	txs := make(map[uint64]uint64)
+	pages := make([]bm.DirtyPage, 0)
+	for i := uint64(1); i <= 30; i++ {
+		txs[i] = i * 100
+		pages = append(pages, bm.DirtyPage{Blk: fm.NewBlockId("test_file", i), RecLSN: i})
+	}
+	// 一条日志放不下，拆成多条之后合起来和原来的内容相同
+	_, err = WriteNQCheckPoint(logManager, 7, txs, pages)
+	require.Nil(t, err)
+	parts := lastNQCheckPoint(logManager)
+	require.Greater(t, len(parts), 1)
+	require.True(t, parts[0].Final())
+	gotTxs := make(map[uint64]uint64)
+	gotPages := make(map[uint64]uint64)
+	for _, part := range parts {
+		require.Equal(t, uint64(7), part.BeginLSN())
+		for txNum, lastLSN := range part.ActiveTxs() {
+			gotTxs[txNum] = lastLSN
+		}
+		for _, page := range part.DirtyPages() {
+			gotPages[page.Blk.Number()] = page.RecLSN
+		}
+	}
+	require.Equal(t, txs, gotTxs)
+	require.Equal(t, 30, len(gotPages))
+	require.Equal(t, uint64(12), gotPages[12])
+}
+
+func TestCheckpointScheduler_Triggers(t *testing.T) {
+	fileManager, logManager, bufferManager := openTestDb(t, filepath.Join(t.TempDir(), "checkpoint"))
+
+	scheduler := NewCheckpointScheduler(logManager, bufferManager, CheckpointConfig{LogBytes: 800})
+	done, err := scheduler.RunOnce()
+	require.Nil(t, err)
+	require.False(t, done)
+	// 写入超过两个区块的日志之后触发
+	tx := NewTransaction(fileManager, logManager, bufferManager)
+	blk := fm.NewBlockId("test_file", 1)
+	require.Nil(t, tx.Pin(blk))
+	for i := 0; i < 20; i++ {
+		require.Nil(t, tx.SetInt(blk, 80, int64(i), true))
+	}
+	done, err = scheduler.RunOnce()
+	require.Nil(t, err)
+	require.True(t, done)
+	done, _ = scheduler.RunOnce()
+	require.False(t, done)
+	tx.Commit()
+
+	scheduler = NewCheckpointScheduler(logManager, bufferManager, CheckpointConfig{Interval: 10 * time.Millisecond, PollInterval: time.Millisecond})
+	scheduler.Start()
+	require.Eventually(t, func() bool { return scheduler.Checkpoints() >= 2 }, time.Second, time.Millisecond)
+	scheduler.Stop()
+	require.Nil(t, scheduler.Err())
+}
+
+func TestCheckpoint_MasterRecord(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "checkpoint")
+	fileManager, logManager, bufferManager := openTestDb(t, dir)
+	blk1 := fm.NewBlockId("test_file", 1)
+	blk2 := fm.NewBlockId("test_file", 2)
+
+	tx1 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx1.Pin(blk1))
+	require.Nil(t, tx1.SetInt(blk1, 80, 1, true))
+	tx2 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx2.Pin(blk2))
+	require.Nil(t, tx2.SetInt(blk2, 80, 2, true))
+	tx2.Commit()
+	lsn, err := Checkpoint(logManager, bufferManager)
+	require.Nil(t, err)
+	saved, ok := logManager.LastCheckpoint()
+	require.True(t, ok)
+	require.Equal(t, lsn, saved)
+	require.Nil(t, tx1.SetInt(blk1, 120, 1, true))
+	require.Nil(t, logManager.Flush())
+
+	// 重启时从主记录指向的检查点开始分析，tx1在检查点之前的修改也要撤销
+	fileManager, logManager, bufferManager = openTestDb(t, dir)
+	NewTransaction(fileManager, logManager, bufferManager).Recover()
+	p := readDisk(t, fileManager, blk1)
+	require.Equal(t, uint64(0), p.GetInt(80))
+	require.Equal(t, uint64(0), p.GetInt(120))
+	require.Equal(t, uint64(2), readDisk(t, fileManager, blk2).GetInt(80))
+
+	// 恢复结束之后主记录指向恢复写入的静止检查点
+	saved, ok = logManager.LastCheckpoint()
+	require.True(t, ok)
+	bytes, err := logManager.ReadAt(saved)
+	require.Nil(t, err)
+	rec, err := DecodeLogRecord(nil, bytes)
+	require.Nil(t, err)
+	require.Equal(t, CHECKPOINT, rec.Op())
+}
@@ -10,6 +10,18 @@ type CommitRecord struct {
 	txNum uint64
 }
 
+func init() {
+	mustRegisterLogRecord(COMMIT, LogRecordHandler{
+		Name: "COMMIT",
+		Decode: func(_ *lg.LogManager, p *fm.Page) (LogRecordInterface, error) {
+			if err := checkRecordSize(p, COMMIT, 2*UINT64_LENGTH); err != nil {
+				return nil, err
+			}
+			return NewCommitRecord(p), nil
+		},
+	})
+}
+
 func NewCommitRecord(p *fm.Page) *CommitRecord {
 	return &CommitRecord{
 		txNum: p.GetInt(UINT64_LENGTH),
@@ -24,10 +36,6 @@ func (c *CommitRecord) TxNumber() uint64 {
 	return c.txNum
 }
 
-func (c *CommitRecord) Undo(tx TransactionInterface) {
-	// 没有回滚操作
-}
-
 func (c *CommitRecord) ToString() string {
 	return fmt.Sprintf("<COMMIT %d>", c.txNum)
 }
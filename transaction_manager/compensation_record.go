@@ -0,0 +1,147 @@
+package transaction_manager
+
+import (
+	"fmt"
+	fm "simpleDb/file_manager"
+	lg "simpleDb/log_manager"
+)
+
+/*
+CompensationRecord 补偿日志(CLR)，回滚撤销一条修改之前写入，<CLR, txNum, prevLSN, undoNextLSN, fileName, blkNum, offset, image>。
+CLR只会被重做不会被撤销。image是撤销之后页面上从offset开始被改变的字节，重做就是把它写回页面。
+补偿日志可能比被撤销的日志长，写入修改之前会同时检查两者的长度，能写入的修改一定能回滚。undoNext指向被撤销日志的prevLSN，
+回滚过程中崩溃之后，恢复时从undoNext继续回滚，已经撤销过的修改不会被再撤销一次。
+被撤销的是逻辑日志时，撤销过程写入了自己的物理日志，补偿日志只用来跳过已经撤销的日志，没有区块，重做时什么也不做。
+*/
+type CompensationRecord struct {
+	txNum    uint64
+	prevLSN  uint64
+	undoNext uint64
+	blk      *fm.BlockId // 撤销逻辑日志时为nil
+	offset   uint64
+	image    []byte
+}
+
+func init() {
+	mustRegisterLogRecord(CLR, LogRecordHandler{
+		Name: "CLR",
+		Decode: func(_ *lg.LogManager, p *fm.Page) (LogRecordInterface, error) {
+			rec, err := NewCompensationRecord(p)
+			if err != nil {
+				return nil, err
+			}
+			return rec, nil
+		},
+		// 补偿日志不会被撤销
+		Redo: func(rec LogRecordInterface, tx TransactionInterface) error {
+			return rec.(*CompensationRecord).Redo(tx)
+		},
+	})
+}
+
+func NewCompensationRecord(p *fm.Page) (*CompensationRecord, error) {
+	r := newRecordReader(p, CLR)
+	c := &CompensationRecord{
+		txNum:    r.readInt("txNum"),
+		prevLSN:  r.readInt("prevLSN"),
+		undoNext: r.readInt("undoNext"),
+	}
+	fileName := r.readString("fileName")
+	blkNum := r.readInt("blkNum")
+	c.offset = r.readInt("offset")
+	c.image = r.readBytes("image")
+	if r.err != nil {
+		return nil, r.err
+	}
+	if fileName != "" {
+		c.blk = fm.NewBlockId(fileName, blkNum)
+	}
+	return c, nil
+}
+
+func (c *CompensationRecord) Op() RECORD_TYPE {
+	return CLR
+}
+
+func (c *CompensationRecord) TxNumber() uint64 {
+	return c.txNum
+}
+
+func (c *CompensationRecord) PrevLSN() uint64 {
+	return c.prevLSN
+}
+
+// UndoNext 回滚时下一条需要撤销的日志，0表示已经撤销到了事务的开始
+func (c *CompensationRecord) UndoNext() uint64 {
+	return c.undoNext
+}
+
+// Block 被撤销的日志是逻辑日志时返回nil，逻辑撤销过程中写入的物理日志会各自重做
+func (c *CompensationRecord) Block() *fm.BlockId {
+	return c.blk
+}
+
+func (c *CompensationRecord) Offset() uint64 {
+	return c.offset
+}
+
+// Image 撤销之后页面上从Offset开始的内容
+func (c *CompensationRecord) Image() []byte {
+	return c.image
+}
+
+func (c *CompensationRecord) Redo(tx TransactionInterface) error {
+	if c.blk == nil {
+		return nil
+	}
+	if err := tx.Pin(c.blk); err != nil {
+		return err
+	}
+	defer tx.Unpin(c.blk)
+	return tx.SetBytes(c.blk, c.offset, c.image, false)
+}
+
+func (c *CompensationRecord) ToString() string {
+	if c.blk == nil {
+		return fmt.Sprintf("<CLR %d %d>", c.txNum, c.undoNext)
+	}
+	return fmt.Sprintf("<CLR %d %d %d %d %x>", c.txNum, c.undoNext, c.blk.Number(), c.offset, c.image)
+}
+
+// WriteCompensationLog 写入补偿日志，撤销逻辑日志时blk为nil
+// clrLogSize 撤销blk上的修改时，改变imageLen个字节的补偿日志的字节数，blk为nil表示撤销的是逻辑日志
+func clrLogSize(blk *fm.BlockId, imageLen uint64) uint64 {
+	fileName := ""
+	if blk != nil {
+		fileName = blk.FileName()
+	}
+	p := fm.NewPageBySize(1)
+	return 7*UINT64_LENGTH + p.MaxLengthForString(fileName) + imageLen
+}
+
+func WriteCompensationLog(logManager *lg.LogManager, txNum uint64, prevLSN uint64, undoNext uint64, blk *fm.BlockId, offset uint64, image []byte) (uint64, error) {
+	fileName, blkNum := "", uint64(0)
+	if blk != nil {
+		fileName, blkNum = blk.FileName(), blk.Number()
+	}
+	tPos := uint64(UINT64_LENGTH)
+	pPos := tPos + UINT64_LENGTH
+	uPos := pPos + UINT64_LENGTH
+	fPos := uPos + UINT64_LENGTH
+	p := fm.NewPageBySize(1)
+	bPos := fPos + p.MaxLengthForString(fileName)
+	oPos := bPos + UINT64_LENGTH
+	iPos := oPos + UINT64_LENGTH
+	rec := make([]byte, clrLogSize(blk, uint64(len(image))))
+	p = fm.NewPageByBytes(rec)
+	p.SetInt(0, uint64(CLR))
+	p.SetInt(tPos, txNum)
+	p.SetInt(pPos, prevLSN)
+	p.SetInt(uPos, undoNext)
+	p.SetString(fPos, fileName)
+	p.SetInt(bPos, blkNum)
+	p.SetInt(oPos, offset)
+	p.SetBytes(iPos, image)
+
+	return logManager.Append(rec)
+}
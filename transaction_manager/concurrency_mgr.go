@@ -0,0 +1,143 @@
+package transaction_manager
+
+import fm "simpleDb/file_manager"
+
+/*
+ConcurrencyManager 每个事务有一个，记录事务持有了哪些锁。读数据前获取共享锁，写数据前获取排他锁，
+所有的锁都持有到事务提交或者回滚时才释放(严格两阶段锁)。
+加锁按照文件、区块、记录从上到下进行，在子节点上加锁之前先在父节点上加意向锁。
+事务在一个文件上持有的区块锁和记录锁超过阈值时升级为文件锁，并释放被文件锁覆盖的细粒度锁。
+*/
+type ConcurrencyManager struct {
+	lockTable *LockTable
+	txNum     uint64
+	locks     map[Resource]LockMode
+	fineLocks map[string]int // 每个文件上持有的区块锁和记录锁的数量
+}
+
+func NewConcurrencyManager(lockTable *LockTable, txNum uint64) *ConcurrencyManager {
+	return &ConcurrencyManager{
+		lockTable: lockTable,
+		txNum:     txNum,
+		locks:     make(map[Resource]LockMode),
+		fineLocks: make(map[string]int),
+	}
+}
+
+func (c *ConcurrencyManager) SLock(blk *fm.BlockId) error {
+	return c.lock(BlockResource(blk), S)
+}
+
+func (c *ConcurrencyManager) XLock(blk *fm.BlockId) error {
+	return c.lock(BlockResource(blk), X)
+}
+
+func (c *ConcurrencyManager) SLockRecord(blk *fm.BlockId, slot uint64) error {
+	return c.lock(RecordResource(blk, slot), S)
+}
+
+func (c *ConcurrencyManager) XLockRecord(blk *fm.BlockId, slot uint64) error {
+	return c.lock(RecordResource(blk, slot), X)
+}
+
+// SLockEndOfFile 读取文件大小之前调用，持有期间其他事务不能增加区块
+func (c *ConcurrencyManager) SLockEndOfFile(fileName string) error {
+	return c.lock(endOfFile(fileName), S)
+}
+
+// XLockEndOfFile 增加区块之前调用
+func (c *ConcurrencyManager) XLockEndOfFile(fileName string) error {
+	return c.lock(endOfFile(fileName), X)
+}
+
+// LockFile 直接锁住整个文件，用于全表扫描和修改表结构
+func (c *ConcurrencyManager) LockFile(fileName string, mode LockMode) error {
+	return c.lock(FileResource(fileName), mode)
+}
+
+// Mode 返回事务在对象上直接持有的锁
+func (c *ConcurrencyManager) Mode(resource Resource) LockMode {
+	return c.locks[resource]
+}
+
+// covered 事务在对象或者它的祖先上已经持有了包含请求的锁
+func (c *ConcurrencyManager) covered(resource Resource, mode LockMode) bool {
+	if c.locks[resource].Covers(mode) {
+		return true
+	}
+	for parent, ok := resource.Parent(); ok; parent, ok = parent.Parent() {
+		held := c.locks[parent]
+		// 祖先上的S和SIX包含了所有子节点的读权限，X包含了所有权限
+		if held == X || ((held == S || held == SIX) && S.Covers(mode)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ConcurrencyManager) lock(resource Resource, mode LockMode) error {
+	if c.covered(resource, mode) {
+		return nil
+	}
+	if parent, ok := resource.Parent(); ok {
+		if err := c.lock(parent, mode.intention()); err != nil {
+			return err
+		}
+	}
+	if err := c.lockTable.Lock(c.txNum, resource, mode); err != nil {
+		return err
+	}
+	held, ok := c.locks[resource]
+	c.locks[resource] = join(held, mode)
+	if ok || resource.Level == FILE_LEVEL {
+		return nil
+	}
+	c.fineLocks[resource.FileName]++
+	if n := c.lockTable.EscalationThreshold(); n > 0 && c.fineLocks[resource.FileName] > n {
+		return c.escalate(resource.FileName)
+	}
+	return nil
+}
+
+// escalate 把文件上的细粒度锁升级为文件锁，有排他锁时升级为X，否则升级为S
+func (c *ConcurrencyManager) escalate(fileName string) error {
+	mode := S
+	var fine []Resource
+	for resource, held := range c.locks {
+		if resource.FileName != fileName || resource.Level == FILE_LEVEL {
+			continue
+		}
+		fine = append(fine, resource)
+		if held != S && held != IS {
+			mode = X
+		}
+	}
+	file := FileResource(fileName)
+	if err := c.lockTable.Lock(c.txNum, file, mode); err != nil {
+		return err
+	}
+	c.locks[file] = join(c.locks[file], mode)
+	c.lockTable.UnlockAll(c.txNum, fine)
+	for _, resource := range fine {
+		delete(c.locks, resource)
+	}
+	delete(c.fineLocks, fileName)
+	return nil
+}
+
+// Wounded 事务是否已经被锁表选为牺牲者，这样的事务只能回滚
+func (c *ConcurrencyManager) Wounded() bool {
+	return c.lockTable.Wounded(c.txNum)
+}
+
+// Release 释放事务持有的所有锁
+func (c *ConcurrencyManager) Release() {
+	resources := make([]Resource, 0, len(c.locks))
+	for resource := range c.locks {
+		resources = append(resources, resource)
+	}
+	c.lockTable.UnlockAll(c.txNum, resources)
+	c.lockTable.EndTx(c.txNum)
+	c.locks = make(map[Resource]LockMode)
+	c.fineLocks = make(map[string]int)
+}
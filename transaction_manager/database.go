@@ -0,0 +1,123 @@
+package transaction_manager
+
+import (
+	"fmt"
+	lm "simpleDb/log_manager"
+	"sync"
+)
+
+/*
+database 同一个数据库中所有事务共享的状态。一个数据库对应一个日志文件，因此用日志文件的路径区分不同的数据库，
+打开同一个日志文件的多个日志管理器共享锁表和事务号。数据库第一次被用到时创建，CloseDatabase之后移除
+*/
+type database struct {
+	lockTable *LockTable
+	versions  *VersionStore
+	force     ForcePolicy
+	txMu      sync.Mutex                  // 保护txs，写START、COMMIT和ROLLBACK时持有，检查点看到的活跃事务和日志一致
+	txs       map[uint64]*RecoveryManager // 还没有结束的事务
+	txNums    txNumAllocator
+}
+
+var databasesMu sync.Mutex
+var databases = make(map[string]*database) // 以日志文件的路径为键
+
+func databaseOf(logManager *lm.LogManager) *database {
+	databasesMu.Lock()
+	defer databasesMu.Unlock()
+
+	path := logManager.LogPath()
+	db, ok := databases[path]
+	if !ok {
+		db = &database{
+			lockTable: NewLockTable(),
+			versions:  NewVersionStore(),
+			txs:       make(map[uint64]*RecoveryManager),
+		}
+		databases[path] = db
+	}
+	return db
+}
+
+/*
+CloseDatabase 关闭数据库时调用，移除数据库共享的锁表、版本和事务号等内存状态，提交策略也恢复成默认值。
+还有没结束的事务时返回错误，数据库保持打开。再次使用时重新创建，事务号从磁盘上的高水位和日志中恢复
+*/
+func CloseDatabase(logManager *lm.LogManager) error {
+	databasesMu.Lock()
+	db, ok := databases[logManager.LogPath()]
+	databasesMu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	db.txMu.Lock()
+	active := len(db.txs)
+	db.txMu.Unlock()
+	if active > 0 {
+		return fmt.Errorf("close database %s: %d transactions still active", logManager.LogPath(), active)
+	}
+	dropDatabase(logManager)
+	return nil
+}
+
+// dropDatabase 直接丢弃数据库的内存状态，测试中用来模拟崩溃
+func dropDatabase(logManager *lm.LogManager) {
+	databasesMu.Lock()
+	defer databasesMu.Unlock()
+
+	delete(databases, logManager.LogPath())
+}
+
+// SetForcePolicy 设置数据库(由日志文件的路径区分)的提交策略，默认是NO_FORCE。需要在开始事务之前调用，同一个数据库的事务和恢复过程要使用相同的策略
+func SetForcePolicy(logManager *lm.LogManager, policy ForcePolicy) {
+	db := databaseOf(logManager)
+	databasesMu.Lock()
+	defer databasesMu.Unlock()
+	db.force = policy
+}
+
+func (db *database) policy() ForcePolicy {
+	databasesMu.Lock()
+	defer databasesMu.Unlock()
+	return db.force
+}
+
+// beginTx 写入事务开始的日志并把事务加入活跃事务表
+func (db *database) beginTx(r *RecoveryManager, write func() (uint64, error)) (uint64, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	lsn, err := write()
+	if err != nil {
+		return 0, err
+	}
+	db.txs[r.txNum] = r
+	return lsn, nil
+}
+
+// endTx 写入事务结束的日志并把事务移出活跃事务表，日志写入失败时事务仍然算作活跃
+func (db *database) endTx(txNum uint64, write func() (uint64, error)) (uint64, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	lsn, err := write()
+	if err != nil {
+		return 0, err
+	}
+	delete(db.txs, txNum)
+	return lsn, nil
+}
+
+// activeTxs 返回当前最新的日志编号，以及此时所有活跃事务最近写入的日志
+func (db *database) activeTxs(logManager *lm.LogManager) (uint64, map[uint64]uint64) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	beginLSN := logManager.LatestLSN()
+	txs := make(map[uint64]uint64)
+	for txNum, r := range db.txs {
+		txs[txNum] = r.LastLSN()
+	}
+	return beginLSN, txs
+}
@@ -1,42 +1,86 @@
 package transaction_manager
 
-import fm "simpleDb/file_manager"
+import (
+	"fmt"
+	fm "simpleDb/file_manager"
+	"strings"
+)
 
 type TransactionInterface interface {
-	Commit()
-	Rollback()
-	Recover()
-	Pin(blk *fm.BlockId)
+	Commit() error
+	Rollback() error
+	Recover() error
+	Pin(blk *fm.BlockId) error
 	Unpin(blk *fm.BlockId)
 	GetInt(blk *fm.BlockId, offset uint64) (uint64, error)
 	GetString(blk *fm.BlockId, offset uint64) (string, error)
 	SetInt(blk *fm.BlockId, offset uint64, val int64, okToLog bool) error
 	SetString(blk *fm.BlockId, offset uint64, val string, okToLog bool) error
+	GetBytes(blk *fm.BlockId, offset uint64, length uint64) ([]byte, error)
+	SetBytes(blk *fm.BlockId, offset uint64, val []byte, okToLog bool) error
 	AvailableBuffers() uint64
-	Size(filename string) uint64
-	Append(filename string) *fm.BlockId
+	Size(filename string) (uint64, error)
+	Append(filename string) (*fm.BlockId, error)
 	BlockSize() uint64
 }
 
+/*
+RECORD_TYPE 日志类别，编号写在每条日志的开头，已经写入磁盘的编号不能再修改，新增的类别使用新的编号。
+日志的格式改变时同样换用新的编号，旧编号的日志解析时返回ErrOldLogFormat，不会按照新格式解释旧的数据
+*/
 type RECORD_TYPE uint64
 
 const (
-	CHECKPOINT RECORD_TYPE = iota
-	START
-	COMMIT
-	ROLLBACK
-	SETINT
-	SETSTRING
+	CHECKPOINT   RECORD_TYPE = 0
+	START        RECORD_TYPE = 1
+	COMMIT       RECORD_TYPE = 2
+	ROLLBACK     RECORD_TYPE = 3
+	SETINT_V1    RECORD_TYPE = 4 // 旧格式的SETINT，只有修改之前的值，不再支持
+	SETSTRING_V1 RECORD_TYPE = 5 // 旧格式的SETSTRING，只有修改之前的值，不再支持
+	CLR          RECORD_TYPE = 6 // 补偿日志，回滚时撤销一条修改之前写入
+	NQCKPT       RECORD_TYPE = 7 // 非静止检查点，记录活跃的事务和脏页表
+	SETBYTES     RECORD_TYPE = 8 // 一段字节的前后映像，只包含实际改变的部分
+	SETINT       RECORD_TYPE = 9 // 带有prevLSN以及修改前后的值
+	SETSTRING    RECORD_TYPE = 10
 )
 
+func (r RECORD_TYPE) String() string {
+	if handler, ok := handlerOf(r); ok {
+		return handler.Name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", uint64(r))
+}
+
+// ParseRecordType 根据名称(不区分大小写)获得日志类别，包括其他模块注册的日志
+func ParseRecordType(name string) (RECORD_TYPE, error) {
+	for _, t := range RecordTypes() {
+		if strings.EqualFold(t.String(), name) {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log record type: %s", name)
+}
+
 const (
 	UINT64_LENGTH = 8
 	END_OF_FILE   = -1
 )
 
+// LogRecordInterface 日志记录，撤销和重做由注册的LogRecordHandler完成
 type LogRecordInterface interface {
-	Op() RECORD_TYPE              // 返回记录的类别
-	TxNumber() uint64             // 返回事务的id
-	Undo(tx TransactionInterface) // 回滚操作
-	ToString() string             // 获得记录的字符串内容
+	Op() RECORD_TYPE  // 返回记录的类别
+	TxNumber() uint64 // 返回事务的id
+	ToString() string // 获得记录的字符串内容
+}
+
+// TxRecord 回滚时需要撤销的日志，通过prevLSN串成事务的日志链
+type TxRecord interface {
+	LogRecordInterface
+	PrevLSN() uint64 // 同一个事务的上一条日志
+}
+
+// PageRecord 修改了某个区块的日志，恢复时根据页面上保存的日志编号判断是否需要重做
+type PageRecord interface {
+	TxRecord
+	Block() *fm.BlockId // 修改的区块，补偿日志撤销的是逻辑日志时为nil
 }
@@ -0,0 +1,37 @@
+package transaction_manager
+
+import "fmt"
+
+// IsolationLevel 事务的隔离级别
+type IsolationLevel int
+
+const (
+	READ_UNCOMMITTED IsolationLevel = iota // 直接读页面上的值，可能读到没有提交的修改
+	READ_COMMITTED                         // 每次读取时看到最新提交的值，不加共享锁
+	REPEATABLE_READ                        // 快照隔离，读取事务开始时的快照，默认的隔离级别
+	SERIALIZABLE                           // 严格两阶段锁，共享锁持有到事务结束，读取文件大小时锁住文件末尾防止幻读
+)
+
+var isolationLevelNames = map[IsolationLevel]string{
+	READ_UNCOMMITTED: "READ UNCOMMITTED",
+	READ_COMMITTED:   "READ COMMITTED",
+	REPEATABLE_READ:  "REPEATABLE READ",
+	SERIALIZABLE:     "SERIALIZABLE",
+}
+
+func (i IsolationLevel) String() string {
+	if name, ok := isolationLevelNames[i]; ok {
+		return name
+	}
+	return fmt.Sprintf("IsolationLevel(%d)", int(i))
+}
+
+// TxOption 用于在创建Transaction的时候修改默认配置
+type TxOption func(t *Transaction)
+
+// WithIsolationLevel 指定事务的隔离级别，默认使用REPEATABLE_READ
+func WithIsolationLevel(level IsolationLevel) TxOption {
+	return func(t *Transaction) {
+		t.isolation = level
+	}
+}
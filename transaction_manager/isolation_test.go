@@ -0,0 +1,119 @@
+package transaction_manager
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	fm "simpleDb/file_manager"
+	"testing"
+	"time"
+)
+
+// anomalyWriter 在另一个事务中修改数据，由调用者决定提交还是回滚
+func anomalyWriter(t *testing.T, tx *Transaction, blk *fm.BlockId, val int64) {
+	require.Nil(t, tx.Pin(blk))
+	require.Nil(t, tx.SetInt(blk, 80, val, true))
+}
+
+func TestIsolation_ReadUncommitted(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 8)
+	blk := fm.NewBlockId("test_file", 1)
+
+	writer := NewTransaction(fileManager, logManager, bufferManager)
+	anomalyWriter(t, writer, blk, 42)
+	reader := NewTransaction(fileManager, logManager, bufferManager, WithIsolationLevel(READ_UNCOMMITTED))
+	require.Equal(t, READ_UNCOMMITTED, reader.IsolationLevel())
+	require.Nil(t, reader.Pin(blk))
+	// 允许脏读
+	val, err := reader.GetInt(blk, 80)
+	require.Nil(t, err)
+	require.Equal(t, uint64(42), val)
+	writer.Rollback()
+	val, err = reader.GetInt(blk, 80)
+	require.Nil(t, err)
+	require.Equal(t, uint64(0), val)
+	reader.Commit()
+}
+
+func TestIsolation_ReadCommitted(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 8)
+	blk := fm.NewBlockId("test_file", 1)
+
+	writer := NewTransaction(fileManager, logManager, bufferManager)
+	anomalyWriter(t, writer, blk, 42)
+	reader := NewTransaction(fileManager, logManager, bufferManager, WithIsolationLevel(READ_COMMITTED))
+	require.Nil(t, reader.Pin(blk))
+	// 不允许脏读
+	val, err := reader.GetInt(blk, 80)
+	require.Nil(t, err)
+	require.Equal(t, uint64(0), val)
+	writer.Commit()
+	// 允许不可重复读
+	val, err = reader.GetInt(blk, 80)
+	require.Nil(t, err)
+	require.Equal(t, uint64(42), val)
+	reader.Commit()
+}
+
+func TestIsolation_RepeatableRead(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 8)
+	blk := fm.NewBlockId("test_file", 1)
+
+	reader := NewTransaction(fileManager, logManager, bufferManager)
+	require.Equal(t, REPEATABLE_READ, reader.IsolationLevel())
+	require.Nil(t, reader.Pin(blk))
+	size, err := reader.Size("test_file")
+	require.Nil(t, err)
+
+	writer := NewTransaction(fileManager, logManager, bufferManager)
+	anomalyWriter(t, writer, blk, 42)
+	newBlk, err := writer.Append("test_file")
+	require.Nil(t, err)
+	require.Nil(t, writer.Commit())
+
+	// 不允许不可重复读
+	val, err := reader.GetInt(blk, 80)
+	require.Nil(t, err)
+	require.Equal(t, uint64(0), val)
+	// 允许幻读，文件中新增的区块对快照可见
+	newSize, err := reader.Size("test_file")
+	require.Nil(t, err)
+	require.Greater(t, newSize, newBlk.Number())
+	require.Greater(t, newSize, size)
+	reader.Commit()
+}
+
+func TestIsolation_Serializable(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 8)
+	LockTableFor(logManager).SetTimeout(30 * time.Millisecond)
+	blk := fm.NewBlockId("test_file", 1)
+
+	reader := NewTransaction(fileManager, logManager, bufferManager, WithIsolationLevel(SERIALIZABLE))
+	require.Nil(t, reader.Pin(blk))
+	val, err := reader.GetInt(blk, 80)
+	require.Nil(t, err)
+	require.Equal(t, uint64(0), val)
+	size, err := reader.Size("test_file")
+	require.Nil(t, err)
+
+	// 读过的区块和文件末尾都被锁住了，其他事务不能修改数据也不能增加区块
+	writer := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, writer.Pin(blk))
+	err = writer.SetInt(blk, 80, 42, true)
+	require.True(t, errors.Is(err, ErrLockAbort))
+	_, err = writer.Append("test_file")
+	require.True(t, errors.Is(err, ErrLockAbort))
+	require.Nil(t, writer.Rollback())
+
+	val, err = reader.GetInt(blk, 80)
+	require.Nil(t, err)
+	require.Equal(t, uint64(0), val)
+	newSize, err := reader.Size("test_file")
+	require.Nil(t, err)
+	require.Equal(t, size, newSize)
+	require.Nil(t, reader.Commit())
+
+	writer = NewTransaction(fileManager, logManager, bufferManager)
+	_, err = writer.Append("test_file")
+	require.Nil(t, err)
+	require.Nil(t, writer.Commit())
+}
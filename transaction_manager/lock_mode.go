@@ -0,0 +1,142 @@
+package transaction_manager
+
+import (
+	"fmt"
+	"math"
+	fm "simpleDb/file_manager"
+)
+
+// LockMode 多粒度锁的模式，意向锁(IS, IX)加在父节点上，表示事务要在子节点上加共享锁或者排他锁
+type LockMode int
+
+const (
+	NO_LOCK LockMode = iota
+	IS               // 意向共享锁
+	IX               // 意向排他锁
+	S                // 共享锁
+	SIX              // 共享锁加意向排他锁，读整个文件同时修改其中的一部分区块
+	X                // 排他锁
+)
+
+var lockModeNames = map[LockMode]string{
+	NO_LOCK: "NONE",
+	IS:      "IS",
+	IX:      "IX",
+	S:       "S",
+	SIX:     "SIX",
+	X:       "X",
+}
+
+func (m LockMode) String() string {
+	if name, ok := lockModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("LockMode(%d)", int(m))
+}
+
+// compatible[held][requested] 两个事务分别持有的锁能否共存
+var compatible = [6][6]bool{
+	NO_LOCK: {true, true, true, true, true, true},
+	IS:      {true, true, true, true, true, false},
+	IX:      {true, true, true, false, false, false},
+	S:       {true, true, false, true, false, false},
+	SIX:     {true, true, false, false, false, false},
+	X:       {true, false, false, false, false, false},
+}
+
+// Covers 判断持有m是否已经包含了other的所有权限
+func (m LockMode) Covers(other LockMode) bool {
+	switch m {
+	case X:
+		return true
+	case SIX:
+		return other != X
+	case S:
+		return other == NO_LOCK || other == IS || other == S
+	case IX:
+		return other == NO_LOCK || other == IS || other == IX
+	case IS:
+		return other == NO_LOCK || other == IS
+	}
+	return other == NO_LOCK
+}
+
+// join 同时持有两种锁时等价的最弱的锁，用于锁升级
+func join(a, b LockMode) LockMode {
+	if a.Covers(b) {
+		return a
+	}
+	if b.Covers(a) {
+		return b
+	}
+	// 只剩下S和IX组合的情况
+	return SIX
+}
+
+// intention 在子节点上加锁之前需要在父节点上加的意向锁
+func (m LockMode) intention() LockMode {
+	if m == IS || m == S {
+		return IS
+	}
+	return IX
+}
+
+// LockLevel 加锁对象的粒度，文件包含区块，区块包含记录
+type LockLevel int
+
+const (
+	FILE_LEVEL LockLevel = iota
+	BLOCK_LEVEL
+	RECORD_LEVEL
+)
+
+// Resource 可以加锁的对象，可以直接作为map的键
+type Resource struct {
+	Level    LockLevel
+	FileName string
+	BlkNum   uint64
+	Slot     uint64
+}
+
+func FileResource(fileName string) Resource {
+	return Resource{Level: FILE_LEVEL, FileName: fileName}
+}
+
+func BlockResource(blk *fm.BlockId) Resource {
+	return Resource{Level: BLOCK_LEVEL, FileName: blk.FileName(), BlkNum: blk.Number()}
+}
+
+func RecordResource(blk *fm.BlockId, slot uint64) Resource {
+	return Resource{Level: RECORD_LEVEL, FileName: blk.FileName(), BlkNum: blk.Number(), Slot: slot}
+}
+
+// endOfFileBlk 文件末尾标记的区块号，相当于区块号END_OF_FILE，读取文件大小和增加区块的事务在这个标记上加锁
+const endOfFileBlk = math.MaxUint64
+
+func endOfFile(fileName string) Resource {
+	return Resource{Level: BLOCK_LEVEL, FileName: fileName, BlkNum: endOfFileBlk}
+}
+
+// Parent 返回包含当前对象的上一级对象，文件没有上一级
+func (r Resource) Parent() (Resource, bool) {
+	switch r.Level {
+	case RECORD_LEVEL:
+		return Resource{Level: BLOCK_LEVEL, FileName: r.FileName, BlkNum: r.BlkNum}, true
+	case BLOCK_LEVEL:
+		return FileResource(r.FileName), true
+	}
+	return Resource{}, false
+}
+
+func (r Resource) String() string {
+	switch r.Level {
+	case FILE_LEVEL:
+		return fmt.Sprintf("file %s", r.FileName)
+	case BLOCK_LEVEL:
+		if r.BlkNum == endOfFileBlk {
+			return fmt.Sprintf("end of file %s", r.FileName)
+		}
+		return fmt.Sprintf("blk %d of file %s", r.BlkNum, r.FileName)
+	}
+	return fmt.Sprintf("slot %d of blk %d of file %s", r.Slot, r.BlkNum, r.FileName)
+}
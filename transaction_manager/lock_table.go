@@ -0,0 +1,332 @@
+package transaction_manager
+
+import (
+	"errors"
+	"fmt"
+	fm "simpleDb/file_manager"
+	lm "simpleDb/log_manager"
+	"sync"
+	"time"
+)
+
+const (
+	MAX_WAITING_TIME          = 3   // 等待锁的最长时间，单位秒
+	LOCK_ESCALATION_THRESHOLD = 256 // 默认的锁升级阈值
+)
+
+// ErrLockAbort 没能获得锁，事务应该回滚以释放自己持有的锁
+var ErrLockAbort = errors.New("lock abort")
+
+// ErrDeadlock 事务被选为死锁的牺牲者，这种错误同时也是ErrLockAbort
+var ErrDeadlock = errors.New("deadlock victim")
+
+// LockAbortError 记录了哪个事务在等待哪个对象的锁时超时，可以用errors.Is(err, ErrLockAbort)判断
+type LockAbortError struct {
+	TxNum    uint64
+	Resource Resource
+	Mode     LockMode
+}
+
+func (e *LockAbortError) Error() string {
+	return fmt.Sprintf("%v: tx %d waiting for %v lock on %v", ErrLockAbort, e.TxNum, e.Mode, e.Resource)
+}
+
+func (e *LockAbortError) Is(target error) bool {
+	return target == ErrLockAbort
+}
+
+// DeadlockError 事务因为死锁检测或者死锁预防被终止，errors.Is(err, ErrDeadlock)和errors.Is(err, ErrLockAbort)都成立
+type DeadlockError struct {
+	TxNum    uint64
+	Resource Resource
+	Mode     LockMode
+}
+
+func (e *DeadlockError) Error() string {
+	return fmt.Sprintf("%v: tx %d aborted while waiting for %v lock on %v", ErrDeadlock, e.TxNum, e.Mode, e.Resource)
+}
+
+func (e *DeadlockError) Is(target error) bool {
+	return target == ErrDeadlock || target == ErrLockAbort
+}
+
+// DeadlockPolicy 决定事务之间相互等待时怎么处理
+type DeadlockPolicy int
+
+const (
+	DEADLOCK_DETECT DeadlockPolicy = iota // 维护等待图，开始等待时检测环，终止环中最年轻(事务号最大)的事务
+	WAIT_DIE                              // 年老的事务等待年轻的事务，年轻的事务遇到年老的事务直接终止
+	WOUND_WAIT                            // 年老的事务终止(伤害)年轻的持有者，年轻的事务等待年老的事务
+	TIMEOUT_ONLY                          // 不做处理，只依靠等待超时
+)
+
+// lockEntry 一个对象上每个事务持有的锁，同一个事务多次加锁时合并成一个模式
+type lockEntry struct {
+	holders map[uint64]LockMode
+}
+
+// lockRequest 事务正在等待的锁
+type lockRequest struct {
+	resource Resource
+	mode     LockMode
+}
+
+/*
+LockTable 记录文件、区块和记录上的多粒度锁，同一个数据库中的所有事务共用一个LockTable。
+LockTable只检查同一个对象上的锁是否相容，按照从上到下的顺序加意向锁由ConcurrencyManager负责。
+获取不到锁的事务在条件变量上等待，有锁被释放时被唤醒，等待超过timeout时返回LockAbortError。
+正在等待的请求记录在waiting中，等待图的边根据当前的持有者实时计算，不会因为过期的边误判死锁。
+*/
+type LockTable struct {
+	locks     map[Resource]*lockEntry
+	waiting   map[uint64]lockRequest
+	aborted   map[uint64]bool // 被选为牺牲者的事务，下一次检查时返回DeadlockError
+	policy    DeadlockPolicy
+	timeout   time.Duration
+	escalateN int // 事务在一个文件上持有的细粒度锁超过这个数量时升级为文件锁，0表示不升级
+	mu        sync.Mutex
+	cond      *sync.Cond
+}
+
+func NewLockTable() *LockTable {
+	lockTable := &LockTable{
+		locks:     make(map[Resource]*lockEntry),
+		waiting:   make(map[uint64]lockRequest),
+		aborted:   make(map[uint64]bool),
+		policy:    DEADLOCK_DETECT,
+		timeout:   MAX_WAITING_TIME * time.Second,
+		escalateN: LOCK_ESCALATION_THRESHOLD,
+	}
+	lockTable.cond = sync.NewCond(&lockTable.mu)
+	return lockTable
+}
+
+// LockTableFor 返回给定数据库(由日志文件的路径区分)的锁表
+func LockTableFor(logManager *lm.LogManager) *LockTable {
+	return databaseOf(logManager).lockTable
+}
+
+func (l *LockTable) SetDeadlockPolicy(policy DeadlockPolicy) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.policy = policy
+}
+
+func (l *LockTable) SetTimeout(timeout time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.timeout = timeout
+}
+
+// SetEscalationThreshold 设置锁升级的阈值，n为0时关闭锁升级
+func (l *LockTable) SetEscalationThreshold(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.escalateN = n
+}
+
+func (l *LockTable) EscalationThreshold() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.escalateN
+}
+
+// conflicts 返回与请求冲突的持有者，请求的模式和事务自己已经持有的模式合并之后再检查，调用者需要持有锁
+func (l *LockTable) conflicts(txNum uint64, req lockRequest) []uint64 {
+	e, ok := l.locks[req.resource]
+	if !ok {
+		return nil
+	}
+	mode := join(e.holders[txNum], req.mode)
+	holders := make([]uint64, 0)
+	for holder, held := range e.holders {
+		if holder != txNum && !compatible[held][mode] {
+			holders = append(holders, holder)
+		}
+	}
+	return holders
+}
+
+// findCycle 沿着等待图从txNum出发，如果能回到txNum就返回环上的事务
+func (l *LockTable) findCycle(txNum uint64) []uint64 {
+	visited := make(map[uint64]bool)
+	var path []uint64
+	var dfs func(cur uint64) bool
+	dfs = func(cur uint64) bool {
+		req, ok := l.waiting[cur]
+		if !ok {
+			return false
+		}
+		visited[cur] = true
+		path = append(path, cur)
+		for _, next := range l.conflicts(cur, req) {
+			if next == txNum {
+				return true
+			}
+			if !visited[next] && dfs(next) {
+				return true
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if dfs(txNum) {
+		return path
+	}
+	return nil
+}
+
+// abort 把事务标记为牺牲者并唤醒它，调用者需要持有锁
+func (l *LockTable) abort(txNum uint64) {
+	l.aborted[txNum] = true
+	l.cond.Broadcast()
+}
+
+// resolve 按照死锁策略处理一次等待，返回true表示请求者自己应该被终止，调用者需要持有锁
+func (l *LockTable) resolve(txNum uint64, holders []uint64) bool {
+	switch l.policy {
+	case DEADLOCK_DETECT:
+		cycle := l.findCycle(txNum)
+		if cycle == nil {
+			return false
+		}
+		victim := cycle[0]
+		for _, t := range cycle {
+			if t > victim {
+				victim = t
+			}
+		}
+		if victim == txNum {
+			return true
+		}
+		l.abort(victim)
+	case WAIT_DIE:
+		for _, holder := range holders {
+			if holder < txNum {
+				return true
+			}
+		}
+	case WOUND_WAIT:
+		for _, holder := range holders {
+			if holder > txNum && !l.aborted[holder] {
+				l.abort(holder)
+			}
+		}
+	}
+	return false
+}
+
+// acquire 等待直到请求和其他持有者不冲突，超时或者被选为牺牲者时返回错误，调用者需要持有锁
+func (l *LockTable) acquire(txNum uint64, req lockRequest) error {
+	defer delete(l.waiting, txNum)
+	deadline := time.Now().Add(l.timeout)
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		if l.aborted[txNum] {
+			delete(l.aborted, txNum)
+			return &DeadlockError{TxNum: txNum, Resource: req.resource, Mode: req.mode}
+		}
+		holders := l.conflicts(txNum, req)
+		if len(holders) == 0 {
+			return nil
+		}
+		l.waiting[txNum] = req
+		if l.resolve(txNum, holders) {
+			return &DeadlockError{TxNum: txNum, Resource: req.resource, Mode: req.mode}
+		}
+		if !time.Now().Before(deadline) {
+			return &LockAbortError{TxNum: txNum, Resource: req.resource, Mode: req.mode}
+		}
+		if timer == nil {
+			// cond不支持超时，到期时唤醒所有等待者
+			timer = time.AfterFunc(l.timeout, func() {
+				l.mu.Lock()
+				l.cond.Broadcast()
+				l.mu.Unlock()
+			})
+		}
+		l.cond.Wait()
+	}
+}
+
+// Lock 以给定的模式锁住对象，事务已经持有的锁会被升级为两者合并后的模式
+func (l *LockTable) Lock(txNum uint64, resource Resource, mode LockMode) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	req := lockRequest{resource: resource, mode: mode}
+	if err := l.acquire(txNum, req); err != nil {
+		return err
+	}
+	e, ok := l.locks[resource]
+	if !ok {
+		e = &lockEntry{holders: make(map[uint64]LockMode)}
+		l.locks[resource] = e
+	}
+	e.holders[txNum] = join(e.holders[txNum], mode)
+	return nil
+}
+
+// SLock 获取区块的共享锁，只检查区块本身，不会在文件上加意向锁
+func (l *LockTable) SLock(txNum uint64, blk *fm.BlockId) error {
+	return l.Lock(txNum, BlockResource(blk), S)
+}
+
+// XLock 获取区块的排他锁，只检查区块本身，不会在文件上加意向锁
+func (l *LockTable) XLock(txNum uint64, blk *fm.BlockId) error {
+	return l.Lock(txNum, BlockResource(blk), X)
+}
+
+// Unlock 释放事务在对象上持有的锁
+func (l *LockTable) Unlock(txNum uint64, resource Resource) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.unlock(txNum, resource)
+	l.cond.Broadcast()
+}
+
+// UnlockAll 一次释放事务的多个锁，其他事务不会看到只释放了一部分的状态
+func (l *LockTable) UnlockAll(txNum uint64, resources []Resource) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, resource := range resources {
+		l.unlock(txNum, resource)
+	}
+	l.cond.Broadcast()
+}
+
+func (l *LockTable) unlock(txNum uint64, resource Resource) {
+	e, ok := l.locks[resource]
+	if !ok {
+		return
+	}
+	delete(e.holders, txNum)
+	if len(e.holders) == 0 {
+		delete(l.locks, resource)
+	}
+}
+
+// Wounded 事务是否已经被选为牺牲者，例如在WOUND_WAIT下被年老的事务伤害，标记保留到事务结束
+func (l *LockTable) Wounded(txNum uint64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.aborted[txNum]
+}
+
+// EndTx 事务结束时调用，清除事务残留的牺牲者标记
+func (l *LockTable) EndTx(txNum uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.aborted, txNum)
+	delete(l.waiting, txNum)
+}
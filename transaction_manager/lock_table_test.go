@@ -0,0 +1,314 @@
+package transaction_manager
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	fm "simpleDb/file_manager"
+	"testing"
+	"time"
+)
+
+func TestLockTable_SharedAndExclusive(t *testing.T) {
+	lockTable := NewLockTable()
+	lockTable.timeout = 30 * time.Millisecond
+	blk := fm.NewBlockId("test_file", 1)
+
+	require.Nil(t, lockTable.SLock(1, blk))
+	require.Nil(t, lockTable.SLock(2, blk))
+	// 其他事务还持有共享锁，不能获得排他锁
+	err := lockTable.XLock(1, blk)
+	require.True(t, errors.Is(err, ErrLockAbort))
+
+	lockTable.Unlock(2, BlockResource(blk))
+	// 只有自己持有共享锁时可以升级为排他锁
+	require.Nil(t, lockTable.XLock(1, blk))
+	err = lockTable.SLock(3, blk)
+	var abortErr *LockAbortError
+	require.True(t, errors.As(err, &abortErr))
+	require.Equal(t, uint64(3), abortErr.TxNum)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		lockTable.Unlock(1, BlockResource(blk))
+	}()
+	lockTable.timeout = time.Second
+	require.Nil(t, lockTable.SLock(3, blk))
+	lockTable.Unlock(3, BlockResource(blk))
+	require.Equal(t, 0, len(lockTable.locks))
+}
+
+func TestTransaction_WriteWaitsForCommit(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 8)
+	blk := fm.NewBlockId("test_file", 1)
+
+	tx1 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx1.Pin(blk))
+	require.Nil(t, tx1.SetInt(blk, 80, 42, true))
+
+	// tx1持有排他锁，tx2修改同一个区块超时
+	LockTableFor(logManager).SetTimeout(30 * time.Millisecond)
+	defer LockTableFor(logManager).SetTimeout(MAX_WAITING_TIME * time.Second)
+	tx2 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx2.Pin(blk))
+	err := tx2.SetInt(blk, 80, 7, true)
+	require.True(t, errors.Is(err, ErrLockAbort))
+	tx2.Rollback()
+
+	// tx1提交之后tx3可以获得排他锁，tx3修改的是其他数据，没有写冲突
+	LockTableFor(logManager).SetTimeout(time.Second)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		tx1.Commit()
+	}()
+	tx3 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx3.Pin(blk))
+	require.Nil(t, tx3.SetInt(blk, 88, 7, true))
+	tx3.Commit()
+}
+
+func TestLockTable_DeadlockDetection(t *testing.T) {
+	lockTable := NewLockTable()
+	blk1 := fm.NewBlockId("test_file", 1)
+	blk2 := fm.NewBlockId("test_file", 2)
+
+	// 请求者自己是环中最年轻的事务，直接被终止
+	require.Nil(t, lockTable.XLock(1, blk1))
+	require.Nil(t, lockTable.XLock(2, blk2))
+	done := make(chan error)
+	go func() {
+		done <- lockTable.XLock(1, blk2)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	err := lockTable.XLock(2, blk1)
+	var deadlockErr *DeadlockError
+	require.True(t, errors.As(err, &deadlockErr))
+	require.Equal(t, uint64(2), deadlockErr.TxNum)
+	require.True(t, errors.Is(err, ErrLockAbort))
+	lockTable.Unlock(2, BlockResource(blk2))
+	lockTable.EndTx(2)
+	require.Nil(t, <-done)
+	lockTable.Unlock(1, BlockResource(blk1))
+	lockTable.Unlock(1, BlockResource(blk2))
+
+	// 正在等待的年轻事务被选为牺牲者，请求者继续等待
+	require.Nil(t, lockTable.XLock(2, blk1))
+	require.Nil(t, lockTable.XLock(1, blk2))
+	go func() {
+		err := lockTable.XLock(2, blk2)
+		lockTable.Unlock(2, BlockResource(blk1))
+		lockTable.EndTx(2)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.Nil(t, lockTable.XLock(1, blk1))
+	require.True(t, errors.Is(<-done, ErrDeadlock))
+}
+
+func TestLockTable_WaitDie(t *testing.T) {
+	lockTable := NewLockTable()
+	lockTable.SetDeadlockPolicy(WAIT_DIE)
+	lockTable.SetTimeout(30 * time.Millisecond)
+	blk := fm.NewBlockId("test_file", 1)
+
+	// 年轻的事务遇到年老的持有者立即终止
+	require.Nil(t, lockTable.SLock(1, blk))
+	err := lockTable.XLock(2, blk)
+	require.True(t, errors.Is(err, ErrDeadlock))
+	lockTable.Unlock(1, BlockResource(blk))
+
+	// 年老的事务等待年轻的持有者，最终只会超时
+	require.Nil(t, lockTable.SLock(2, blk))
+	err = lockTable.XLock(1, blk)
+	require.True(t, errors.Is(err, ErrLockAbort))
+	require.False(t, errors.Is(err, ErrDeadlock))
+}
+
+func TestLockTable_WoundWait(t *testing.T) {
+	lockTable := NewLockTable()
+	lockTable.SetDeadlockPolicy(WOUND_WAIT)
+	blk1 := fm.NewBlockId("test_file", 1)
+	blk2 := fm.NewBlockId("test_file", 2)
+
+	require.Nil(t, lockTable.XLock(2, blk1))
+	done := make(chan error)
+	go func() {
+		// 年老的事务伤害年轻的持有者后等待它释放锁
+		done <- lockTable.XLock(1, blk1)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	// 被伤害的事务在下一次请求锁时终止
+	err := lockTable.SLock(2, blk2)
+	require.True(t, errors.Is(err, ErrDeadlock))
+	lockTable.Unlock(2, BlockResource(blk1))
+	lockTable.EndTx(2)
+	require.Nil(t, <-done)
+
+	// 年轻的事务等待年老的持有者
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		lockTable.Unlock(1, BlockResource(blk1))
+	}()
+	require.Nil(t, lockTable.SLock(3, blk1))
+}
+
+func TestTransaction_WoundedHolderFails(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 8)
+	LockTableFor(logManager).SetDeadlockPolicy(WOUND_WAIT)
+	blk := fm.NewBlockId("test_file", 1)
+
+	old := NewTransaction(fileManager, logManager, bufferManager)
+	young := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, young.Pin(blk))
+	require.Nil(t, young.SetInt(blk, 0, 22, true))
+	require.Nil(t, old.Pin(blk))
+
+	done := make(chan error)
+	go func() {
+		done <- old.SetInt(blk, 0, 11, true)
+	}()
+	for !LockTableFor(logManager).Wounded(young.txNum) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 年轻的事务不再请求锁，也不调用Rollback，下一次操作发现自己被伤害，自动回滚并释放锁
+	_, err := young.GetInt(blk, 0)
+	require.True(t, errors.Is(err, ErrDeadlock))
+	require.Equal(t, ABORTED, young.State())
+	require.Nil(t, <-done)
+	err = young.Commit()
+	require.True(t, errors.Is(err, ErrTxNotActive))
+
+	val, err := old.GetInt(blk, 0)
+	require.Nil(t, err)
+	require.Equal(t, uint64(11), val)
+	require.Nil(t, old.Commit())
+}
+
+func TestTransaction_WoundedHolderCommitRollsBack(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 8)
+	LockTableFor(logManager).SetDeadlockPolicy(WOUND_WAIT)
+	blk := fm.NewBlockId("test_file", 1)
+
+	old := NewTransaction(fileManager, logManager, bufferManager)
+	young := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, young.Pin(blk))
+	require.Nil(t, young.SetInt(blk, 0, 22, true))
+	require.Nil(t, old.Pin(blk))
+
+	done := make(chan error)
+	go func() {
+		done <- old.SetInt(blk, 0, 11, true)
+	}()
+	for !LockTableFor(logManager).Wounded(young.txNum) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 被伤害的事务直接提交，提交失败并回滚
+	err := young.Commit()
+	require.True(t, errors.Is(err, ErrDeadlock))
+	require.Equal(t, ABORTED, young.State())
+	require.Nil(t, <-done)
+	require.Nil(t, old.Commit())
+}
+
+func TestTransaction_DeadlockVictimRollsBack(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 8)
+	blk1 := fm.NewBlockId("test_file", 1)
+	blk2 := fm.NewBlockId("test_file", 2)
+
+	tx1 := NewTransaction(fileManager, logManager, bufferManager)
+	tx2 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx1.Pin(blk1))
+	require.Nil(t, tx1.Pin(blk2))
+	require.Nil(t, tx2.Pin(blk1))
+	require.Nil(t, tx2.Pin(blk2))
+	require.Nil(t, tx1.SetInt(blk1, 0, 11, true))
+	require.Nil(t, tx2.SetInt(blk2, 0, 22, true))
+
+	done := make(chan error)
+	go func() {
+		done <- tx1.SetInt(blk2, 0, 12, true)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	// tx2更年轻，被选为牺牲者并自动回滚，释放的锁让tx1继续执行
+	err := tx2.SetInt(blk1, 0, 21, true)
+	require.True(t, errors.Is(err, ErrDeadlock))
+	require.Nil(t, <-done)
+
+	val, err := tx1.GetInt(blk2, 0)
+	require.Nil(t, err)
+	require.Equal(t, uint64(12), val)
+	tx1.Commit()
+
+	tx3 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx3.Pin(blk1))
+	val, err = tx3.GetInt(blk1, 0)
+	require.Nil(t, err)
+	require.Equal(t, uint64(11), val)
+	tx3.Commit()
+}
+
+func TestLockMode_Compatibility(t *testing.T) {
+	require.True(t, compatible[IS][IX])
+	require.True(t, compatible[IS][SIX])
+	require.False(t, compatible[IX][S])
+	require.False(t, compatible[SIX][IX])
+	require.True(t, compatible[S][S])
+	require.False(t, compatible[X][IS])
+
+	require.Equal(t, SIX, join(S, IX))
+	require.Equal(t, SIX, join(IX, S))
+	require.Equal(t, S, join(IS, S))
+	require.Equal(t, X, join(SIX, X))
+	require.True(t, SIX.Covers(S))
+	require.False(t, S.Covers(IX))
+}
+
+func TestConcurrencyManager_IntentionLocks(t *testing.T) {
+	lockTable := NewLockTable()
+	lockTable.SetTimeout(30 * time.Millisecond)
+	blk1 := fm.NewBlockId("test_file", 1)
+	blk2 := fm.NewBlockId("test_file", 2)
+
+	c1 := NewConcurrencyManager(lockTable, 1)
+	c2 := NewConcurrencyManager(lockTable, 2)
+	require.Nil(t, c1.XLock(blk1))
+	require.Equal(t, IX, c1.Mode(FileResource("test_file")))
+	// 不同区块上的锁通过文件上相容的意向锁共存
+	require.Nil(t, c2.SLock(blk2))
+	require.Equal(t, IS, c2.Mode(FileResource("test_file")))
+	// 读整个文件和tx1的意向排他锁冲突
+	err := c2.LockFile("test_file", S)
+	require.True(t, errors.Is(err, ErrLockAbort))
+	c1.Release()
+	require.Nil(t, c2.LockFile("test_file", S))
+	// 持有文件的共享锁之后读区块不需要再加锁，写区块时文件锁升级为SIX
+	require.Nil(t, c2.SLockRecord(blk1, 3))
+	require.Equal(t, NO_LOCK, c2.Mode(RecordResource(blk1, 3)))
+	require.Nil(t, c2.XLock(blk1))
+	require.Equal(t, SIX, c2.Mode(FileResource("test_file")))
+	require.Equal(t, X, c2.Mode(BlockResource(blk1)))
+	c2.Release()
+	require.Equal(t, 0, len(lockTable.locks))
+}
+
+func TestConcurrencyManager_Escalation(t *testing.T) {
+	lockTable := NewLockTable()
+	lockTable.SetEscalationThreshold(4)
+	c := NewConcurrencyManager(lockTable, 1)
+
+	for i := uint64(0); i < 3; i++ {
+		require.Nil(t, c.SLock(fm.NewBlockId("test_file", i)))
+	}
+	require.Equal(t, IS, c.Mode(FileResource("test_file")))
+	require.Nil(t, c.XLockRecord(fm.NewBlockId("test_file", 0), 1))
+	require.Equal(t, SIX, c.Mode(BlockResource(fm.NewBlockId("test_file", 0))))
+	// 第五个细粒度锁触发升级，有排他锁所以升级为X，区块和记录上的锁被释放
+	require.Nil(t, c.SLock(fm.NewBlockId("test_file", 3)))
+	require.Equal(t, X, c.Mode(FileResource("test_file")))
+	require.Equal(t, 1, len(lockTable.locks))
+	require.Nil(t, c.XLock(fm.NewBlockId("test_file", 9)))
+	require.Equal(t, 1, len(lockTable.locks))
+	c.Release()
+	require.Equal(t, 0, len(lockTable.locks))
+}
@@ -0,0 +1,116 @@
+package transaction_manager
+
+import (
+	"fmt"
+	fm "simpleDb/file_manager"
+	lm "simpleDb/log_manager"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// USER_RECORD_TYPE_BASE 其他模块自定义的日志类别从这个编号开始，更小的编号留给事务管理器自己的日志
+const USER_RECORD_TYPE_BASE RECORD_TYPE = 1000
+
+/*
+LogRecordHandler 描述一种日志如何解析、撤销和重做。
+Undo为nil表示日志没有需要撤销的修改；修改页面的日志(PageRecord)在持有页面排他latch时用TxSub撤销，
+其他带有prevLSN的日志(TxRecord)是逻辑日志，撤销时通过事务本身执行相反的操作，操作过程会写入自己的日志。
+Redo只对修改页面的日志有效，页面上的日志编号保证每条日志只重做一次，逻辑日志的修改由它执行时写入的物理日志重做
+*/
+type LogRecordHandler struct {
+	Name   string // 日志类别的名称，日志查看工具用它显示和过滤日志
+	Decode func(logManager *lm.LogManager, p *fm.Page) (LogRecordInterface, error)
+	Undo   func(rec LogRecordInterface, tx TransactionInterface) error
+	Redo   func(rec LogRecordInterface, tx TransactionInterface) error
+}
+
+var registryMu sync.RWMutex
+var logRecordHandlers = make(map[RECORD_TYPE]LogRecordHandler)
+
+/*
+RegisterLogRecord 注册一种自定义的日志，一般在模块的init中调用。日志类别的编号会写入磁盘，
+必须使用固定的常量并且不小于USER_RECORD_TYPE_BASE，编号或者名称已经被注册时返回错误
+*/
+func RegisterLogRecord(t RECORD_TYPE, handler LogRecordHandler) error {
+	if t < USER_RECORD_TYPE_BASE {
+		return fmt.Errorf("log record type %d is reserved, custom types start at %d", uint64(t), uint64(USER_RECORD_TYPE_BASE))
+	}
+	return registerLogRecord(t, handler)
+}
+
+func registerLogRecord(t RECORD_TYPE, handler LogRecordHandler) error {
+	if handler.Name == "" || handler.Decode == nil {
+		return fmt.Errorf("log record type %d needs a name and a decoder", uint64(t))
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if old, ok := logRecordHandlers[t]; ok {
+		return fmt.Errorf("log record type %d is already registered as %s", uint64(t), old.Name)
+	}
+	for other, h := range logRecordHandlers {
+		if strings.EqualFold(h.Name, handler.Name) {
+			return fmt.Errorf("log record name %s is already used by type %d", handler.Name, uint64(other))
+		}
+	}
+	logRecordHandlers[t] = handler
+	return nil
+}
+
+// mustRegisterLogRecord 注册事务管理器自己的日志，注册失败说明编号冲突，直接panic
+func mustRegisterLogRecord(t RECORD_TYPE, handler LogRecordHandler) {
+	if err := registerLogRecord(t, handler); err != nil {
+		panic(err)
+	}
+}
+
+func handlerOf(t RECORD_TYPE) (LogRecordHandler, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	handler, ok := logRecordHandlers[t]
+	return handler, ok
+}
+
+// RecordTypes 返回所有已经注册的日志类别，按编号排序
+func RecordTypes() []RECORD_TYPE {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	types := make([]RECORD_TYPE, 0, len(logRecordHandlers))
+	for t := range logRecordHandlers {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// DecodeLogRecord 将日志的二进制数据解析成对应的日志记录，logManager只有START记录写日志时才会用到，只读解析时可以传nil
+func DecodeLogRecord(logManager *lm.LogManager, bytes []byte) (LogRecordInterface, error) {
+	if len(bytes) < UINT64_LENGTH {
+		return nil, fmt.Errorf("log record too short: %d bytes", len(bytes))
+	}
+	p := fm.NewPageByBytes(bytes)
+	handler, ok := handlerOf(RECORD_TYPE(p.GetInt(0)))
+	if !ok {
+		return nil, fmt.Errorf("unknown log record type: %d", p.GetInt(0))
+	}
+	return handler.Decode(logManager, p)
+}
+
+// undoLogRecord 用注册的Undo撤销一条日志
+func undoLogRecord(rec LogRecordInterface, tx TransactionInterface) error {
+	handler, ok := handlerOf(rec.Op())
+	if !ok || handler.Undo == nil {
+		return nil
+	}
+	return handler.Undo(rec, tx)
+}
+
+// redoLogRecord 用注册的Redo重做一条日志
+func redoLogRecord(rec LogRecordInterface, tx TransactionInterface) error {
+	handler, ok := handlerOf(rec.Op())
+	if !ok || handler.Redo == nil {
+		return nil
+	}
+	return handler.Redo(rec, tx)
+}
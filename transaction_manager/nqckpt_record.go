@@ -0,0 +1,187 @@
+package transaction_manager
+
+import (
+	"fmt"
+	"math"
+	bm "simpleDb/buffer_manager"
+	fm "simpleDb/file_manager"
+	lg "simpleDb/log_manager"
+	"sort"
+	"strings"
+)
+
+// activeTx 检查点开始时没有结束的事务以及它最近写入的日志
+type activeTx struct {
+	txNum   uint64
+	lastLSN uint64
+}
+
+/*
+NQCheckPointRecord 非静止检查点，<NQCKPT, beginLSN, final, 活跃事务表, 脏页表>。
+beginLSN是收集两张表之前最新的日志编号，恢复时从这里开始分析。两张表太大放不进一条日志时分成多条写入，
+只有最后一条的final为1，恢复时只认可完整写入的检查点
+*/
+type NQCheckPointRecord struct {
+	beginLSN uint64
+	final    bool
+	txs      []activeTx
+	pages    []bm.DirtyPage
+}
+
+func init() {
+	mustRegisterLogRecord(NQCKPT, LogRecordHandler{
+		Name: "NQCKPT",
+		Decode: func(_ *lg.LogManager, p *fm.Page) (LogRecordInterface, error) {
+			rec, err := NewNQCheckPointRecord(p)
+			if err != nil {
+				return nil, err
+			}
+			return rec, nil
+		},
+	})
+}
+
+func NewNQCheckPointRecord(p *fm.Page) (*NQCheckPointRecord, error) {
+	r := newRecordReader(p, NQCKPT)
+	rec := &NQCheckPointRecord{
+		beginLSN: r.readInt("beginLSN"),
+		final:    r.readInt("final") == 1,
+	}
+	numTxs := r.readInt("numTxs")
+	// 数量字段可能是错误的，读取失败之后立刻停止
+	for i := uint64(0); i < numTxs && r.err == nil; i++ {
+		tx := activeTx{txNum: r.readInt("txNum"), lastLSN: r.readInt("lastLSN")}
+		rec.txs = append(rec.txs, tx)
+	}
+	numPages := r.readInt("numPages")
+	for i := uint64(0); i < numPages && r.err == nil; i++ {
+		fileName := r.readString("fileName")
+		blk := fm.NewBlockId(fileName, r.readInt("blkNum"))
+		rec.pages = append(rec.pages, bm.DirtyPage{Blk: blk, RecLSN: r.readInt("recLSN")})
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return rec, nil
+}
+
+func (n *NQCheckPointRecord) Op() RECORD_TYPE {
+	return NQCKPT
+}
+
+func (n *NQCheckPointRecord) TxNumber() uint64 {
+	return math.MaxUint64 // 没有对应的事务id
+}
+
+func (n *NQCheckPointRecord) BeginLSN() uint64 {
+	return n.beginLSN
+}
+
+// Final 是否是检查点的最后一条日志
+func (n *NQCheckPointRecord) Final() bool {
+	return n.final
+}
+
+// ActiveTxs 返回这条日志中记录的活跃事务以及它们最近写入的日志
+func (n *NQCheckPointRecord) ActiveTxs() map[uint64]uint64 {
+	txs := make(map[uint64]uint64)
+	for _, tx := range n.txs {
+		txs[tx.txNum] = tx.lastLSN
+	}
+	return txs
+}
+
+func (n *NQCheckPointRecord) DirtyPages() []bm.DirtyPage {
+	return n.pages
+}
+
+func (n *NQCheckPointRecord) ToString() string {
+	txs := make([]string, 0, len(n.txs))
+	for _, tx := range n.txs {
+		txs = append(txs, fmt.Sprintf("%d:%d", tx.txNum, tx.lastLSN))
+	}
+	pages := make([]string, 0, len(n.pages))
+	for _, page := range n.pages {
+		pages = append(pages, fmt.Sprintf("%s:%d:%d", page.Blk.FileName(), page.Blk.Number(), page.RecLSN))
+	}
+	final := 0
+	if n.final {
+		final = 1
+	}
+	return fmt.Sprintf("<NQCKPT %d %d [%s] [%s]>", n.beginLSN, final, strings.Join(txs, " "), strings.Join(pages, " "))
+}
+
+/*
+WriteNQCheckPoint 写入非静止检查点，一条日志放不下时拆成多条，返回最后一条日志的编号。
+活跃事务按照事务号排序，脏页按照recLSN排序，这样同样的内容写出来的日志相同
+*/
+func WriteNQCheckPoint(logManager *lg.LogManager, beginLSN uint64, txs map[uint64]uint64, pages []bm.DirtyPage) (uint64, error) {
+	sortedTxs := make([]activeTx, 0, len(txs))
+	for txNum, lastLSN := range txs {
+		sortedTxs = append(sortedTxs, activeTx{txNum: txNum, lastLSN: lastLSN})
+	}
+	sort.Slice(sortedTxs, func(i, j int) bool { return sortedTxs[i].txNum < sortedTxs[j].txNum })
+	sortedPages := make([]bm.DirtyPage, len(pages))
+	copy(sortedPages, pages)
+	sort.Slice(sortedPages, func(i, j int) bool { return sortedPages[i].RecLSN < sortedPages[j].RecLSN })
+
+	p := fm.NewPageBySize(1)
+	maxSize := logManager.MaxRecordSize()
+	for {
+		// 头部: 类别、beginLSN、final、事务数、页面数
+		size := uint64(5 * UINT64_LENGTH)
+		numTxs := 0
+		for numTxs < len(sortedTxs) && size+2*UINT64_LENGTH <= maxSize {
+			size += 2 * UINT64_LENGTH
+			numTxs++
+		}
+		numPages := 0
+		for numPages < len(sortedPages) {
+			pageSize := p.MaxLengthForString(sortedPages[numPages].Blk.FileName()) + 2*UINT64_LENGTH
+			if size+pageSize > maxSize {
+				break
+			}
+			size += pageSize
+			numPages++
+		}
+		final := numTxs == len(sortedTxs) && numPages == len(sortedPages)
+		if !final && numTxs == 0 && numPages == 0 {
+			return 0, fmt.Errorf("dirty page of file %s does not fit in a log record", sortedPages[0].Blk.FileName())
+		}
+
+		lsn, err := writeNQCheckPointPart(logManager, beginLSN, final, sortedTxs[:numTxs], sortedPages[:numPages], size)
+		if err != nil || final {
+			return lsn, err
+		}
+		sortedTxs = sortedTxs[numTxs:]
+		sortedPages = sortedPages[numPages:]
+	}
+}
+
+func writeNQCheckPointPart(logManager *lg.LogManager, beginLSN uint64, final bool, txs []activeTx, pages []bm.DirtyPage, size uint64) (uint64, error) {
+	rec := make([]byte, size)
+	p := fm.NewPageByBytes(rec)
+	p.SetInt(0, uint64(NQCKPT))
+	p.SetInt(UINT64_LENGTH, beginLSN)
+	if final {
+		p.SetInt(2*UINT64_LENGTH, 1)
+	}
+	pos := uint64(3 * UINT64_LENGTH)
+	p.SetInt(pos, uint64(len(txs)))
+	pos += UINT64_LENGTH
+	for _, tx := range txs {
+		p.SetInt(pos, tx.txNum)
+		p.SetInt(pos+UINT64_LENGTH, tx.lastLSN)
+		pos += 2 * UINT64_LENGTH
+	}
+	p.SetInt(pos, uint64(len(pages)))
+	pos += UINT64_LENGTH
+	for _, page := range pages {
+		p.SetString(pos, page.Blk.FileName())
+		pos += p.MaxLengthForString(page.Blk.FileName())
+		p.SetInt(pos, page.Blk.Number())
+		p.SetInt(pos+UINT64_LENGTH, page.RecLSN)
+		pos += 2 * UINT64_LENGTH
+	}
+	return logManager.Append(rec)
+}
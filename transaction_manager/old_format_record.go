@@ -0,0 +1,36 @@
+package transaction_manager
+
+import (
+	"errors"
+	"fmt"
+	fm "simpleDb/file_manager"
+	lm "simpleDb/log_manager"
+)
+
+// ErrOldLogFormat 日志是旧版本的格式写入的，当前版本不能解析
+var ErrOldLogFormat = errors.New("log record uses an unsupported old format")
+
+/*
+旧格式的SETINT和SETSTRING是<SETINT, txNum, fileName, blkNum, offset, oldVal>，没有prevLSN和修改之后的值，
+既不能沿着prevLSN回滚也不能重做。这两个编号只注册解析函数，返回明确的错误，
+恢复和日志查看工具遇到它们时停止或者跳过，而不是把旧数据当成新格式读出错误的字段
+*/
+func init() {
+	for _, old := range []struct {
+		op   RECORD_TYPE
+		name string
+		now  RECORD_TYPE
+	}{
+		{SETINT_V1, "SETINT_V1", SETINT},
+		{SETSTRING_V1, "SETSTRING_V1", SETSTRING},
+	} {
+		old := old
+		mustRegisterLogRecord(old.op, LogRecordHandler{
+			Name: old.name,
+			Decode: func(_ *lm.LogManager, _ *fm.Page) (LogRecordInterface, error) {
+				return nil, fmt.Errorf("%w: %s (type %d) was written before prevLSN and new values were logged, current format is type %d",
+					ErrOldLogFormat, old.name, uint64(old.op), uint64(old.now))
+			},
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package transaction_manager
+
+import (
+	"errors"
+	"fmt"
+	fm "simpleDb/file_manager"
+)
+
+// ErrBadLogRecord 日志的内容和它的类别对不上，例如字段的长度超出了日志本身
+var ErrBadLogRecord = errors.New("malformed log record")
+
+/*
+recordReader 从日志开头的类别之后按顺序读取字段，每次读取之前检查字段是否超出日志的范围。
+日志可能是损坏的或者是其他格式写入的，长度字段不能直接拿来分配内存。出错之后的读取都返回零值，解析完成后检查err
+*/
+type recordReader struct {
+	p   *fm.Page
+	op  RECORD_TYPE
+	pos uint64
+	err error
+}
+
+func newRecordReader(p *fm.Page, op RECORD_TYPE) *recordReader {
+	r := &recordReader{p: p, op: op, pos: UINT64_LENGTH}
+	if p.Size() < UINT64_LENGTH {
+		r.pos = p.Size()
+	}
+	return r
+}
+
+// remaining 当前位置之后还有多少字节
+func (r *recordReader) remaining() uint64 {
+	return r.p.Size() - r.pos
+}
+
+func (r *recordReader) fail(field string, need uint64) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: %s needs %d bytes for %s at offset %d, record has %d bytes",
+			ErrBadLogRecord, r.op, need, field, r.pos, r.p.Size())
+	}
+}
+
+func (r *recordReader) readInt(field string) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if r.remaining() < UINT64_LENGTH {
+		r.fail(field, UINT64_LENGTH)
+		return 0
+	}
+	val := r.p.GetInt(r.pos)
+	r.pos += UINT64_LENGTH
+	return val
+}
+
+func (r *recordReader) readBytes(field string) []byte {
+	length := r.readInt(field + " length")
+	if r.err != nil {
+		return nil
+	}
+	if r.remaining() < length {
+		r.fail(field, length)
+		return nil
+	}
+	val := r.p.GetRawBytes(r.pos, length)
+	r.pos += length
+	return val
+}
+
+func (r *recordReader) readString(field string) string {
+	return string(r.readBytes(field))
+}
+
+// checkRecordSize 定长的日志在解析之前检查长度
+func checkRecordSize(p *fm.Page, op RECORD_TYPE, size uint64) error {
+	if p.Size() < size {
+		return fmt.Errorf("%w: %s needs %d bytes, record has %d bytes", ErrBadLogRecord, op, size, p.Size())
+	}
+	return nil
+}
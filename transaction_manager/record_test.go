@@ -2,8 +2,10 @@ package transaction_manager
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/require"
+	"path/filepath"
 	fm "simpleDb/file_manager"
 	lm "simpleDb/log_manager"
 	"testing"
@@ -42,14 +44,15 @@ func TestNewSetStringRecord(t *testing.T) {
 	txNum := uint64(1)
 	offset := uint64(13)
 	// 写入用于恢复日志
-	WriteSetStringLog(logManager, txNum, dummy_blk, offset, str)
+	WriteSetStringLog(logManager, txNum, 0, dummy_blk, offset, str, "modify string 2")
 	pp := fm.NewPageBySize(400)
 	pp.SetString(offset, str)
 	iterator := logManager.Iterator()
 	rec := iterator.Next()
 	logP := fm.NewPageByBytes(rec)
-	setStrRec := NewSetStringRecord(logP)
-	expectedStr := fmt.Sprintf("<SETSTRING %d %d %d %s>", txNum, blk, offset, str)
+	setStrRec, err := NewSetStringRecord(logP)
+	require.Nil(t, err)
+	expectedStr := fmt.Sprintf("<SETSTRING %d %d %d %s %s>", txNum, blk, offset, str, "modify string 2")
 	require.Equal(t, expectedStr, setStrRec.ToString())
 
 	pp.SetString(offset, "modify string 1")
@@ -58,6 +61,9 @@ func TestNewSetStringRecord(t *testing.T) {
 	setStrRec.Undo(txSub)
 	recover_str := pp.GetString(offset)
 	require.Equal(t, recover_str, str)
+
+	setStrRec.Redo(txSub)
+	require.Equal(t, "modify string 2", pp.GetString(offset))
 }
 
 func TestNewSetIntRecord(t *testing.T) {
@@ -70,14 +76,15 @@ func TestNewSetIntRecord(t *testing.T) {
 	txNum := uint64(1)
 	offset := uint64(13)
 	// 写入用于恢复日志
-	WriteSetIntLog(logManager, txNum, dummyBlockId, offset, val)
+	WriteSetIntLog(logManager, txNum, 0, dummyBlockId, offset, val, 33)
 	pp := fm.NewPageBySize(400)
 	pp.SetInt(offset, val)
 	iterator := logManager.Iterator()
 	rec := iterator.Next()
 	logP := fm.NewPageByBytes(rec)
-	setIntRec := NewSetIntRecord(logP)
-	expectedStr := fmt.Sprintf("<SETINT %d %d %d %d>", txNum, blk, offset, val)
+	setIntRec, err := NewSetIntRecord(logP)
+	require.Nil(t, err)
+	expectedStr := fmt.Sprintf("<SETINT %d %d %d %d %d>", txNum, blk, offset, val, 33)
 
 	require.Equal(t, expectedStr, setIntRec.ToString())
 
@@ -88,6 +95,72 @@ func TestNewSetIntRecord(t *testing.T) {
 	recoverVal := pp.GetInt(offset)
 
 	require.Equal(t, recoverVal, val)
+
+	setIntRec.Redo(txSub)
+	require.Equal(t, uint64(33), pp.GetInt(offset))
+}
+
+func TestNewSetBytesRecord(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "recordTest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "setBytes")
+
+	blk := fm.NewBlockId("dummyId", 1)
+	_, err := WriteSetBytesLog(logManager, 5, 9, blk, 20, []byte{1, 2, 3}, []byte{7, 8, 9})
+	require.Nil(t, err)
+	logRecord, err := DecodeLogRecord(nil, logManager.Iterator().Next())
+	require.Nil(t, err)
+	rec := logRecord.(*SetBytesRecord)
+	require.Equal(t, SETBYTES, rec.Op())
+	require.Equal(t, uint64(9), rec.PrevLSN())
+	require.Equal(t, "<SETBYTES 5 1 20 010203 070809>", rec.ToString())
+
+	pp := fm.NewPageBySize(400)
+	txSub := NewTxSub(pp)
+	require.Nil(t, rec.Redo(txSub))
+	require.Equal(t, []byte{0, 7, 8, 9, 0}, pp.GetRawBytes(19, 5))
+	require.Nil(t, rec.Undo(txSub))
+	require.Equal(t, []byte{0, 1, 2, 3, 0}, pp.GetRawBytes(19, 5))
+}
+
+func TestDecodeLogRecord_Malformed(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "recordTest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "malformed")
+
+	blk := fm.NewBlockId("dummyId", 1)
+	_, err := WriteSetStringLog(logManager, 1, 0, blk, 13, "old", "new")
+	require.Nil(t, err)
+	rec := logManager.Iterator().Next()
+
+	// 截断的日志
+	for _, size := range []int{2 * UINT64_LENGTH, len(rec) - 1} {
+		_, err = DecodeLogRecord(nil, rec[:size])
+		require.True(t, errors.Is(err, ErrBadLogRecord), "size %d: %v", size, err)
+	}
+	// 字符串长度字段是垃圾数据
+	garbage := make([]byte, len(rec))
+	copy(garbage, rec)
+	fm.NewPageByBytes(garbage).SetInt(3*UINT64_LENGTH, 1<<62)
+	_, err = DecodeLogRecord(nil, garbage)
+	require.True(t, errors.Is(err, ErrBadLogRecord))
+
+	// 定长的日志和检查点日志
+	p := fm.NewPageBySize(UINT64_LENGTH + 4)
+	p.SetInt(0, uint64(COMMIT))
+	_, err = DecodeLogRecord(nil, p.GetRawBytes(0, p.Size()))
+	require.True(t, errors.Is(err, ErrBadLogRecord))
+	p = fm.NewPageBySize(4 * UINT64_LENGTH)
+	p.SetInt(0, uint64(NQCKPT))
+	p.SetInt(3*UINT64_LENGTH, 1<<40)
+	_, err = DecodeLogRecord(nil, p.GetRawBytes(0, p.Size()))
+	require.True(t, errors.Is(err, ErrBadLogRecord))
+}
+
+func TestChangedRange(t *testing.T) {
+	start, end := changedRange([]byte{1, 2, 3, 4, 5}, []byte{1, 9, 3, 9, 5})
+	require.Equal(t, uint64(1), start)
+	require.Equal(t, uint64(4), end)
+	start, end = changedRange([]byte{1, 2}, []byte{1, 2})
+	require.Equal(t, start, end)
 }
 
 func TestNewRollBackRecord(t *testing.T) {
@@ -135,3 +208,151 @@ func TestCheckPointRecord(t *testing.T) {
 	expectedStr := fmt.Sprintf("<CHECKPOINT>")
 	require.Equal(t, expectedStr, record.ToString())
 }
+
+func TestNewCompensationRecord(t *testing.T) {
+	fileManager, _ := fm.NewFileManager(filepath.Join(t.TempDir(), "recordTest"), 400)
+	logManager, _ := lm.NewLogManager(fileManager, "clr")
+
+	blk := fm.NewBlockId("dummyId", 1)
+	ip := fm.NewPageBySize(UINT64_LENGTH)
+	ip.SetInt(0, 11)
+	image := ip.GetRawBytes(0, UINT64_LENGTH)
+	_, err := WriteCompensationLog(logManager, 7, 5, 3, blk, 13, image)
+	require.Nil(t, err)
+	iterator := logManager.Iterator()
+	logRecord, err := DecodeLogRecord(nil, iterator.Next())
+	require.Nil(t, err)
+	clr := logRecord.(*CompensationRecord)
+	require.Equal(t, CLR, clr.Op())
+	require.Equal(t, uint64(7), clr.TxNumber())
+	require.Equal(t, uint64(5), clr.PrevLSN())
+	require.Equal(t, uint64(3), clr.UndoNext())
+	require.Equal(t, "dummyId", clr.Block().FileName())
+	require.Equal(t, uint64(13), clr.Offset())
+	require.Equal(t, "<CLR 7 3 1 13 0b00000000000000>", clr.ToString())
+
+	// 重做补偿日志就是把撤销之后的内容写回页面
+	pp := fm.NewPageBySize(400)
+	pp.SetInt(13, 22)
+	require.Nil(t, clr.Redo(NewTxSub(pp)))
+	require.Equal(t, uint64(11), pp.GetInt(13))
+
+	// 撤销逻辑日志的补偿日志没有区块
+	_, err = WriteCompensationLog(logManager, 7, 6, 5, nil, 0, nil)
+	require.Nil(t, err)
+	iterator = logManager.Iterator()
+	logRecord, err = DecodeLogRecord(nil, iterator.Next())
+	require.Nil(t, err)
+	clr = logRecord.(*CompensationRecord)
+	require.Nil(t, clr.Block())
+	require.Equal(t, "<CLR 7 5>", clr.ToString())
+	require.Nil(t, clr.Redo(NewTxSub(pp)))
+}
+
+const ADDINT = USER_RECORD_TYPE_BASE
+
+// addIntRecord 测试用的逻辑日志，<ADDINT, txNum, prevLSN, fileName, blkNum, offset, delta>，撤销时通过事务减去delta
+type addIntRecord struct {
+	txNum   uint64
+	prevLSN uint64
+	blk     *fm.BlockId
+	offset  uint64
+	delta   uint64
+}
+
+func init() {
+	err := RegisterLogRecord(ADDINT, LogRecordHandler{
+		Name: "ADDINT",
+		Decode: func(_ *lm.LogManager, p *fm.Page) (LogRecordInterface, error) {
+			fileName := p.GetString(3 * UINT64_LENGTH)
+			pos := 3*UINT64_LENGTH + p.MaxLengthForString(fileName)
+			return &addIntRecord{
+				txNum:   p.GetInt(UINT64_LENGTH),
+				prevLSN: p.GetInt(2 * UINT64_LENGTH),
+				blk:     fm.NewBlockId(fileName, p.GetInt(pos)),
+				offset:  p.GetInt(pos + UINT64_LENGTH),
+				delta:   p.GetInt(pos + 2*UINT64_LENGTH),
+			}, nil
+		},
+		Undo: func(rec LogRecordInterface, tx TransactionInterface) error {
+			a := rec.(*addIntRecord)
+			if err := tx.Pin(a.blk); err != nil {
+				return err
+			}
+			defer tx.Unpin(a.blk)
+			val, err := tx.GetInt(a.blk, a.offset)
+			if err != nil {
+				return err
+			}
+			return tx.SetInt(a.blk, a.offset, int64(val-a.delta), true)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (a *addIntRecord) Op() RECORD_TYPE {
+	return ADDINT
+}
+
+func (a *addIntRecord) TxNumber() uint64 {
+	return a.txNum
+}
+
+func (a *addIntRecord) PrevLSN() uint64 {
+	return a.prevLSN
+}
+
+func (a *addIntRecord) ToString() string {
+	return fmt.Sprintf("<ADDINT %d %d %d %d>", a.txNum, a.blk.Number(), a.offset, a.delta)
+}
+
+// addInt 写入逻辑日志之后给区块中的整数加上delta，页面的修改本身不记录日志
+func addInt(t *testing.T, tx *Transaction, blk *fm.BlockId, offset uint64, delta uint64) {
+	p := fm.NewPageBySize(1)
+	pos := 3*UINT64_LENGTH + p.MaxLengthForString(blk.FileName())
+	rec := make([]byte, pos+3*UINT64_LENGTH)
+	p = fm.NewPageByBytes(rec)
+	p.SetInt(0, uint64(ADDINT))
+	p.SetInt(UINT64_LENGTH, tx.txNum)
+	p.SetString(3*UINT64_LENGTH, blk.FileName())
+	p.SetInt(pos, blk.Number())
+	p.SetInt(pos+UINT64_LENGTH, offset)
+	p.SetInt(pos+2*UINT64_LENGTH, delta)
+	_, err := tx.recoveryManager.appendLog(func(prevLSN uint64) (uint64, error) {
+		p.SetInt(2*UINT64_LENGTH, prevLSN)
+		return tx.logManager.Append(rec)
+	})
+	require.Nil(t, err)
+
+	val, err := tx.GetInt(blk, offset)
+	require.Nil(t, err)
+	require.Nil(t, tx.SetInt(blk, offset, int64(val+delta), false))
+}
+
+func TestRegisterLogRecord(t *testing.T) {
+	handler := LogRecordHandler{
+		Name: "CUSTOM",
+		Decode: func(_ *lm.LogManager, _ *fm.Page) (LogRecordInterface, error) {
+			return NewCheckPointRecord(), nil
+		},
+	}
+	// 小于USER_RECORD_TYPE_BASE的编号留给事务管理器，已经注册过的编号和名称不能再用
+	require.NotNil(t, RegisterLogRecord(RECORD_TYPE(100), handler))
+	require.NotNil(t, RegisterLogRecord(ADDINT, handler))
+	handler.Name = "setint"
+	require.NotNil(t, RegisterLogRecord(ADDINT+1, handler))
+	require.NotNil(t, RegisterLogRecord(ADDINT+1, LogRecordHandler{Name: "NO_DECODER"}))
+
+	require.Equal(t, "ADDINT", ADDINT.String())
+	recType, err := ParseRecordType("addint")
+	require.Nil(t, err)
+	require.Equal(t, ADDINT, recType)
+	require.Equal(t, "UNKNOWN(5000)", RECORD_TYPE(5000).String())
+
+	unknown := make([]byte, UINT64_LENGTH)
+	fm.NewPageByBytes(unknown).SetInt(0, 5000)
+	_, err = DecodeLogRecord(nil, unknown)
+	require.NotNil(t, err)
+}
@@ -1,129 +1,510 @@
 package transaction_manager
 
 import (
+	"errors"
+	"fmt"
 	bm "simpleDb/buffer_manager"
 	fm "simpleDb/file_manager"
 	lm "simpleDb/log_manager"
+	"sync"
 )
 
+// ForcePolicy 决定事务提交时是否需要把修改过的页面写入磁盘
+type ForcePolicy int
+
+const (
+	NO_FORCE ForcePolicy = iota // 提交时只把日志写入磁盘，恢复时通过重做找回已提交事务的修改
+	FORCE                       // 提交和回滚时把修改过的页面写入磁盘，恢复时不重做已经提交的事务，只需要撤销
+)
+
+// ErrLogTooLarge 一次修改需要的日志超过了一条日志的最大长度，修改没有执行
+var ErrLogTooLarge = errors.New("change does not fit in one log record")
+
+/*
+RecoveryManager 按照ARIES的方式记录日志和恢复。每条修改日志记录同一个事务的上一条日志(prevLSN)，
+每个数据页面的末尾记录最近一次修改页面的日志编号(pageLSN)，回滚时每撤销一条修改先写入一条补偿日志(CLR)。
+*/
 type RecoveryManager struct {
 	logManager    *lm.LogManager
 	bufferManager *bm.BufferManager
 	tx            *Transaction
-	txNum         int32
+	txNum         uint64
+	lastLSN       uint64     // 事务最近写入的一条日志
+	mu            sync.Mutex // 保护lastLSN，写日志期间持有，检查点读到的lastLSN不会比已经写入的日志旧
+	forcePolicy   ForcePolicy
+	db            *database
 }
 
-func NewRecoveryManager(tx *Transaction, txNm int32, logManager *lm.LogManager, bufferManager *bm.BufferManager) *RecoveryManager {
+// NewRecoveryManager 写入事务的开始日志并登记到活跃事务表，开始日志写入失败时返回错误，事务没有登记
+func NewRecoveryManager(tx *Transaction, txNm uint64, logManager *lm.LogManager, bufferManager *bm.BufferManager) (*RecoveryManager, error) {
 	recoveryManager := &RecoveryManager{
 		tx:            tx,
 		txNum:         txNm,
 		logManager:    logManager,
 		bufferManager: bufferManager,
+		db:            databaseOf(logManager),
 	}
+	recoveryManager.forcePolicy = recoveryManager.db.policy()
 	p := fm.NewPageBySize(32)
 	p.SetInt(0, uint64(START))
-	p.SetInt(8, uint64(txNm))
+	p.SetInt(8, txNm)
 	startRecord := NewStartRecord(logManager, p)
-	startRecord.WriteToLog()
+	lsn, err := recoveryManager.db.beginTx(recoveryManager, startRecord.WriteToLog)
+	if err != nil {
+		return recoveryManager, fmt.Errorf("write start record of tx %d: %w", txNm, err)
+	}
+	recoveryManager.lastLSN = lsn
 
-	return recoveryManager
+	return recoveryManager, nil
 }
 
+/*
+Commit 写入提交日志并把它写入磁盘。提交日志没有写入日志缓冲区时返回普通的错误，事务仍然活跃；
+提交日志已经写入缓冲区但是写盘失败时返回ErrNotDurable，事务已经结束
+*/
 func (r *RecoveryManager) Commit() error {
-	r.bufferManager.FlushAll(r.txNum)
-	lsn, err := WriteCommitRecord(r.logManager, uint64(r.txNum))
+	if r.forcePolicy == FORCE {
+		if err := r.bufferManager.FlushAll(r.txNum); err != nil {
+			return err
+		}
+	}
+	lsn, err := r.db.endTx(r.txNum, func() (uint64, error) {
+		return WriteCommitRecord(r.logManager, r.txNum)
+	})
 	if err != nil {
 		return err
 	}
-	r.logManager.FlushByLSN(lsn)
+	if err := r.logManager.FlushByLSN(lsn); err != nil {
+		return fmt.Errorf("%w: %v", ErrNotDurable, err)
+	}
 	return nil
 }
 
+// Rollback 撤销事务的全部修改并写入回滚日志，撤销失败时不写回滚日志，恢复时会从补偿日志的位置继续撤销
 func (r *RecoveryManager) Rollback() error {
-	r.doRollback()
-	r.bufferManager.FlushAll(r.txNum)
-	lsn, err := WriteRollBackLog(r.logManager, uint64(r.txNum))
-	if err != nil {
+	if err := r.doRollback(); err != nil {
 		return err
 	}
-	r.logManager.FlushByLSN(lsn)
+	// 修改已经全部撤销，之后的失败只影响持久性，崩溃之后恢复会沿着补偿日志再回滚一次
+	if r.forcePolicy == FORCE {
+		if err := r.bufferManager.FlushAll(r.txNum); err != nil {
+			return fmt.Errorf("%w: %v", ErrNotDurable, err)
+		}
+	}
+	lsn, err := r.db.endTx(r.txNum, func() (uint64, error) {
+		return WriteRollBackLog(r.logManager, r.txNum)
+	})
+	if err == nil {
+		err = r.logManager.FlushByLSN(lsn)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotDurable, err)
+	}
 	return nil
 }
 
+// Recover 恢复结束之后所有页面都已经写入磁盘，写入静止检查点，执行恢复的事务也随之结束
 func (r *RecoveryManager) Recover() error {
-	r.doRecover()
-	r.bufferManager.FlushAll(r.txNum)
-	lsn, err := WriteCheckPoint(r.logManager)
+	if err := r.doRecover(); err != nil {
+		return err
+	}
+	if err := r.bufferManager.FlushAll(r.txNum); err != nil {
+		return err
+	}
+	lsn, err := r.db.endTx(r.txNum, func() (uint64, error) {
+		return WriteCheckPoint(r.logManager)
+	})
 	if err != nil {
 		return err
 	}
-	r.logManager.FlushByLSN(lsn)
-	return nil
+	return r.logManager.SaveCheckpoint(lsn)
 }
 
+/*
+SetInt 先写日志再修改页面，日志写入失败时页面保持不变。
+SetInt和SetString在写日志之前检查日志的长度，放不进一条日志时返回ErrLogTooLarge
+*/
 func (r *RecoveryManager) SetInt(buffer *bm.Buffer, offset uint64, newVal int64) (uint64, error) {
 	oldVal := buffer.Contents().GetInt(offset)
 	block := buffer.Block()
+	if err := r.checkLogSize(SETINT, block, offset, setIntLogSize(block), clrLogSize(block, UINT64_LENGTH)); err != nil {
+		return 0, err
+	}
+	lsn, err := r.appendLog(func(prevLSN uint64) (uint64, error) {
+		return WriteSetIntLog(r.logManager, r.txNum, prevLSN, block, offset, oldVal, uint64(newVal))
+	})
+	if err != nil {
+		return 0, err
+	}
 	buffer.Contents().SetInt(offset, uint64(newVal))
-	return WriteSetIntLog(r.logManager, uint64(r.txNum), block, offset, oldVal)
+	return lsn, nil
 }
 
+// SetString 日志同时记录新旧两个值，两者加起来放不进一条日志时返回ErrLogTooLarge，页面保持不变
 func (r *RecoveryManager) SetString(buffer *bm.Buffer, offset uint64, newVal string) (uint64, error) {
 	oldVal := buffer.Contents().GetString(offset)
 	block := buffer.Block()
+	clrSize := clrLogSize(block, buffer.Contents().MaxLengthForString(oldVal))
+	if err := r.checkLogSize(SETSTRING, block, offset, setStringLogSize(block, oldVal, newVal), clrSize); err != nil {
+		return 0, err
+	}
+	lsn, err := r.appendLog(func(prevLSN uint64) (uint64, error) {
+		return WriteSetStringLog(r.logManager, r.txNum, prevLSN, block, offset, oldVal, newVal)
+	})
+	if err != nil {
+		return 0, err
+	}
 	buffer.Contents().SetString(offset, newVal)
-	return WriteSetStringLog(r.logManager, uint64(r.txNum), block, offset, oldVal)
-}
-
-func (r *RecoveryManager) CreateLogRecord(bytes []byte) LogRecordInterface {
-	p := fm.NewPageByBytes(bytes)
-	switch RECORD_TYPE(p.GetInt(0)) {
-	case CHECKPOINT:
-		return NewCheckPointRecord()
-	case START:
-		return NewStartRecord(r.logManager, p)
-	case COMMIT:
-		return NewCommitRecord(p)
-	case ROLLBACK:
-		return NewRollBackRecord(p)
-	case SETINT:
-		return NewSetIntRecord(p)
-	case SETSTRING:
-		return NewSetStringRecord(p)
-	default:
-		panic("unknown log interface")
-	}
-}
-
-func (r *RecoveryManager) doRollback() {
+	return lsn, nil
+}
+
+/*
+checkLogSize 同时检查日志和回滚时的补偿日志。补偿日志记录撤销之后改变的字节，还要多记录undoNext和数据的长度，
+可能比被撤销的日志长，例如SETINT的补偿日志比它多8个字节。两者都放得下才能写入，写入的修改一定能回滚
+*/
+func (r *RecoveryManager) checkLogSize(op RECORD_TYPE, blk *fm.BlockId, offset uint64, size uint64, clrSize uint64) error {
+	if clrSize > size {
+		size = clrSize
+	}
+	if size > r.logManager.MaxRecordSize() {
+		return fmt.Errorf("%w: %v at offset %d of blk %d of file %s needs %d bytes, max is %d",
+			ErrLogTooLarge, op, offset, blk.Number(), blk.FileName(), size, r.logManager.MaxRecordSize())
+	}
+	return nil
+}
+
+/*
+SetBytes 只记录新旧数据不同的部分，放不进一条日志时分成多条日志，每条日志写入之后再修改页面上对应的部分。
+返回最后一条日志的编号，数据没有变化时返回0；中途失败时返回已经写入的最后一条日志，对应的修改已经在页面上了
+*/
+func (r *RecoveryManager) SetBytes(buffer *bm.Buffer, offset uint64, newVal []byte) (uint64, error) {
+	p := buffer.Contents()
+	block := buffer.Block()
+	oldVal := p.GetRawBytes(offset, uint64(len(newVal)))
+	start, end := changedRange(oldVal, newVal)
+	overhead := setBytesOverhead(block)
+	if start < end && r.logManager.MaxRecordSize() < overhead+2 {
+		return 0, fmt.Errorf("file name %s is too long to log changes", block.FileName())
+	}
+	chunk := (r.logManager.MaxRecordSize() - overhead) / 2
+	lsn := uint64(0)
+	for pos := start; pos < end; pos += chunk {
+		stop := pos + chunk
+		if stop > end {
+			stop = end
+		}
+		chunkLSN, err := r.appendLog(func(prevLSN uint64) (uint64, error) {
+			return WriteSetBytesLog(r.logManager, r.txNum, prevLSN, block, offset+pos, oldVal[pos:stop], newVal[pos:stop])
+		})
+		if err != nil {
+			return lsn, err
+		}
+		p.SetRawBytes(offset+pos, newVal[pos:stop])
+		lsn = chunkLSN
+	}
+	return lsn, nil
+}
+
+// appendLog 以事务最近写入的日志作为prevLSN写入一条新日志
+func (r *RecoveryManager) appendLog(write func(prevLSN uint64) (uint64, error)) (uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lsn, err := write(r.lastLSN)
+	if err != nil {
+		return 0, err
+	}
+	r.lastLSN = lsn
+	return lsn, nil
+}
+
+// LastLSN 返回事务最近写入的日志编号
+func (r *RecoveryManager) LastLSN() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastLSN
+}
+
+// decode 解析日志，遇到无法解析的日志时返回错误，恢复和回滚在这里停下来
+func (r *RecoveryManager) decode(bytes []byte) (LogRecordInterface, error) {
+	return DecodeLogRecord(r.logManager, bytes)
+}
+
+// RollbackTo 撤销编号大于lsn的修改，撤销时同样写入补偿日志，事务可以继续执行
+func (r *RecoveryManager) RollbackTo(lsn uint64) error {
+	return r.undoUntil(lsn)
+}
+
+func (r *RecoveryManager) doRollback() error {
+	return r.undoUntil(0)
+}
+
+/*
+undoUntil 沿着事务的日志链从后往前撤销事务的修改，直到日志编号不大于stop。
+每条日志都记录了同一个事务的上一条日志，按编号直接读取，不需要遍历其他事务的日志
+*/
+func (r *RecoveryManager) undoUntil(stop uint64) error {
+	txNum := r.txNum
+	logCLR := func(undoNext uint64, blk *fm.BlockId, offset uint64, image []byte) (uint64, error) {
+		return r.appendLog(func(prevLSN uint64) (uint64, error) {
+			return WriteCompensationLog(r.logManager, txNum, prevLSN, undoNext, blk, offset, image)
+		})
+	}
+	for undoNext := r.LastLSN(); undoNext > stop; {
+		bytes, err := r.logManager.ReadAt(undoNext)
+		if err != nil {
+			return err
+		}
+		logRecord, err := r.decode(bytes)
+		if err != nil {
+			return err
+		}
+		if undoNext, err = r.undo(logRecord, logCLR); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clrWriter 写入一条补偿日志并返回它的编号，撤销逻辑日志时blk为nil
+type clrWriter func(undoNext uint64, blk *fm.BlockId, offset uint64, image []byte) (uint64, error)
+
+/*
+undo 撤销一条日志，返回事务中下一条需要撤销的日志。物理日志先在页面的副本上撤销，
+补偿日志只记录副本和页面不同的部分，写入之后再把这部分复制到页面上。
+补偿日志的写入和页面的修改都在持有页面排他latch的时候进行，检查点不会看到写了补偿日志但是还没有修改的页面。
+遇到补偿日志时直接跳到它的undoNext，它撤销过的修改不会被再撤销一次；遇到START时返回0，回滚结束。
+逻辑日志通过事务本身撤销，撤销过程写入的日志排在补偿日志之前，撤销到一半崩溃时先撤销这些日志再重新撤销逻辑日志
+*/
+func (r *RecoveryManager) undo(logRecord LogRecordInterface, logCLR clrWriter) (uint64, error) {
+	switch rec := logRecord.(type) {
+	case *CompensationRecord:
+		return rec.UndoNext(), nil
+	case PageRecord:
+		err := r.tx.modifyPage(rec.Block(), func(p *fm.Page, _ uint64) (uint64, error) {
+			size := r.tx.BlockSize()
+			before := p.GetRawBytes(0, size)
+			undone := fm.NewPageByBytes(p.GetRawBytes(0, size))
+			if err := undoLogRecord(rec, NewTxSub(undone)); err != nil {
+				return 0, err
+			}
+			after := undone.GetRawBytes(0, size)
+			start, end := changedRange(before, after)
+			clrLSN, err := logCLR(rec.PrevLSN(), rec.Block(), start, after[start:end])
+			if err != nil {
+				return 0, err
+			}
+			p.SetRawBytes(start, after[start:end])
+			return clrLSN, nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		return rec.PrevLSN(), nil
+	case TxRecord:
+		if err := undoLogRecord(rec, r.tx); err != nil {
+			return 0, err
+		}
+		if _, err := logCLR(rec.PrevLSN(), nil, 0, nil); err != nil {
+			return 0, err
+		}
+		return rec.PrevLSN(), nil
+	}
+	return 0, nil
+}
+
+// redo 页面上记录的日志编号比日志小时说明修改还没有写入页面，重做修改并更新页面的日志编号
+func (r *RecoveryManager) redo(rec PageRecord, lsn uint64) error {
+	return r.tx.modifyPage(rec.Block(), func(p *fm.Page, pageLSN uint64) (uint64, error) {
+		if pageLSN >= lsn {
+			return 0, nil
+		}
+		if err := redoLogRecord(rec, NewTxSub(p)); err != nil {
+			return 0, err
+		}
+		return lsn, nil
+	})
+}
+
+// pageKey 恢复时用来记录脏页表
+type pageKey struct {
+	fileName string
+	blkNum   uint64
+}
+
+func pageKeyOf(blk *fm.BlockId) pageKey {
+	return pageKey{fileName: blk.FileName(), blkNum: blk.Number()}
+}
+
+/*
+lastCheckpoint 返回分析阶段开始的日志编号，0表示从头开始。优先使用主记录指向的检查点，
+直接读出检查点日志；没有主记录或者主记录指向的日志不是完整的检查点时，从日志末尾往前找到最近一个完整的检查点
+*/
+func (r *RecoveryManager) lastCheckpoint() (uint64, error) {
+	if lsn, ok := r.logManager.LastCheckpoint(); ok {
+		if bytes, err := r.logManager.ReadAt(lsn); err == nil {
+			logRecord, err := DecodeLogRecord(nil, bytes)
+			if rec, isNQ := logRecord.(*NQCheckPointRecord); err == nil && isNQ && rec.Final() {
+				return rec.BeginLSN(), nil
+			}
+			if err == nil && logRecord.Op() == CHECKPOINT {
+				return lsn, nil
+			}
+		}
+	}
+
 	iterator := r.logManager.Iterator()
 	for iterator.HasNext() {
-		rec := iterator.Next()
-		logRecord := r.CreateLogRecord(rec)
-		if logRecord.TxNumber() == uint64(r.txNum) {
-			if logRecord.Op() == START {
-				return
+		logRecord, err := r.decode(iterator.Next())
+		if err != nil {
+			return 0, err
+		}
+		switch rec := logRecord.(type) {
+		case *CheckPointRecord:
+			return iterator.LSN(), nil
+		case *NQCheckPointRecord:
+			if rec.Final() {
+				return rec.BeginLSN(), nil
 			}
-			logRecord.Undo(r.tx)
 		}
 	}
+	return 0, nil
 }
 
-func (r *RecoveryManager) doRecover() {
+/*
+doRecover 分三个阶段恢复:
+分析阶段从最近的检查点往后读，找出没有完成的事务和它们的最后一条日志，以及每个被修改过的页面最早的修改(recLSN)，
+非静止检查点记录的活跃事务和脏页表作为分析的起点；
+重做阶段从最小的recLSN往后重复所有的修改和补偿日志，包括没有完成的事务，页面上的日志编号保证每个修改只执行一次。
+FORCE策略下提交的事务在写入提交日志之前已经把页面写入磁盘，重做时跳过它们的日志。回滚日志可能是恢复过程写入的，
+写入时页面还没有写回磁盘，所以回滚的事务仍然重做，撤销阶段沿着补偿日志跳过的修改在页面上已经撤销了；
+撤销阶段沿着每个没有完成的事务的日志链从后往前撤销修改，并写入补偿日志，撤销到START时写入ROLLBACK。
+恢复过程中再次崩溃时，补偿日志会在下一次恢复时被重做，撤销从补偿日志的undoNext继续。
+*/
+func (r *RecoveryManager) doRecover() error {
+	lastLSN := make(map[uint64]uint64)
 	finishedTxs := make(map[uint64]bool)
-	iterator := r.logManager.Iterator()
-	for iterator.HasNext() {
-		bytes := iterator.Next()
-		logRecord := r.CreateLogRecord(bytes)
-		if logRecord.Op() == CHECKPOINT {
-			return
+	committedTxs := make(map[uint64]bool)
+	dirtyPages := make(map[pageKey]uint64)
+	beginLSN, err := r.lastCheckpoint()
+	if err != nil {
+		return err
+	}
+	forward := r.logManager.ForwardIteratorFromLSN(beginLSN)
+	for forward.HasNext() {
+		logRecord, err := r.decode(forward.Next())
+		if err != nil {
+			return err
+		}
+		lsn := forward.LSN()
+		if ckpt, ok := logRecord.(*NQCheckPointRecord); ok {
+			if ckpt.BeginLSN() != beginLSN {
+				continue
+			}
+			// 检查点中的表和检查点之后的日志合并，事务取最新的日志，页面取最早的修改
+			for txNum, txLSN := range ckpt.ActiveTxs() {
+				if txLSN > lastLSN[txNum] {
+					lastLSN[txNum] = txLSN
+				}
+			}
+			for _, page := range ckpt.DirtyPages() {
+				key := pageKeyOf(page.Blk)
+				if recLSN, ok := dirtyPages[key]; !ok || page.RecLSN < recLSN {
+					dirtyPages[key] = page.RecLSN
+				}
+			}
+			continue
+		}
+		txNum := logRecord.TxNumber()
+		if logRecord.Op() == CHECKPOINT || txNum == r.txNum {
+			// 执行恢复的事务自己的START
+			continue
+		}
+		if lsn > lastLSN[txNum] {
+			lastLSN[txNum] = lsn
 		}
 		if logRecord.Op() == COMMIT || logRecord.Op() == ROLLBACK {
-			finishedTxs[logRecord.TxNumber()] = true
+			finishedTxs[txNum] = true
+		}
+		if logRecord.Op() == COMMIT {
+			committedTxs[txNum] = true
+		}
+		if rec, ok := logRecord.(PageRecord); ok && rec.Block() != nil {
+			if _, dirty := dirtyPages[pageKeyOf(rec.Block())]; !dirty {
+				dirtyPages[pageKeyOf(rec.Block())] = lsn
+			}
+		}
+	}
+
+	if len(dirtyPages) > 0 {
+		redoLSN := uint64(0)
+		for _, recLSN := range dirtyPages {
+			if redoLSN == 0 || recLSN < redoLSN {
+				redoLSN = recLSN
+			}
+		}
+		forward = r.logManager.ForwardIteratorFromLSN(redoLSN)
+		for forward.HasNext() {
+			logRecord, err := r.decode(forward.Next())
+			if err != nil {
+				return err
+			}
+			rec, ok := logRecord.(PageRecord)
+			if !ok || rec.Block() == nil {
+				continue
+			}
+			if r.forcePolicy == FORCE && committedTxs[rec.TxNumber()] {
+				continue
+			}
+			if recLSN, dirty := dirtyPages[pageKeyOf(rec.Block())]; dirty && forward.LSN() >= recLSN {
+				if err := r.redo(rec, forward.LSN()); err != nil {
+					return err
+				}
+			}
 		}
-		existed := finishedTxs[logRecord.TxNumber()]
-		if existed {
-			logRecord.Undo(r.tx)
+	}
+
+	undoNext := make(map[uint64]uint64)
+	for txNum, lsn := range lastLSN {
+		if !finishedTxs[txNum] && lsn > 0 {
+			undoNext[txNum] = lsn
 		}
 	}
+	for len(undoNext) > 0 {
+		// 每次撤销所有事务中编号最大的日志，和日志写入的顺序相反
+		txNum, lsn := uint64(0), uint64(0)
+		for tx, next := range undoNext {
+			if next > lsn {
+				txNum, lsn = tx, next
+			}
+		}
+		bytes, err := r.logManager.ReadAt(lsn)
+		if err != nil {
+			return err
+		}
+		logRecord, err := r.decode(bytes)
+		if err != nil {
+			return err
+		}
+		logCLR := func(undoNext uint64, blk *fm.BlockId, offset uint64, image []byte) (uint64, error) {
+			clrLSN, err := WriteCompensationLog(r.logManager, txNum, lastLSN[txNum], undoNext, blk, offset, image)
+			if err == nil {
+				lastLSN[txNum] = clrLSN
+			}
+			return clrLSN, err
+		}
+		next, err := r.undo(logRecord, logCLR)
+		if err != nil {
+			return err
+		}
+		if next > 0 {
+			undoNext[txNum] = next
+			continue
+		}
+		delete(undoNext, txNum)
+		if _, err := WriteRollBackLog(r.logManager, txNum); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,367 @@
+package transaction_manager
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	bm "simpleDb/buffer_manager"
+	fm "simpleDb/file_manager"
+	lm "simpleDb/log_manager"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openTestDb 打开目录中已有的数据库，丢弃之前的内存状态，用新的缓存池模拟崩溃之后重启
+func openTestDb(t *testing.T, dir string) (*fm.FileManager, *lm.LogManager, *bm.BufferManager) {
+	fileManager, err := fm.NewFileManager(dir, 400)
+	require.Nil(t, err)
+	logManager, err := lm.NewLogManager(fileManager, "logfile")
+	require.Nil(t, err)
+	dropDatabase(logManager)
+	return fileManager, logManager, bm.NewBufferManager(fileManager, logManager, 8)
+}
+
+func readDisk(t *testing.T, fileManager *fm.FileManager, blk *fm.BlockId) *fm.Page {
+	p := fm.NewPageBySize(fileManager.BlockSize())
+	// 区块还没有写入过磁盘时读到的是全0
+	if _, err := fileManager.Read(blk, p); err != io.EOF {
+		require.Nil(t, err)
+	}
+	return p
+}
+
+func TestRecoveryManager_RedoCommitted(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "recovery")
+	fileManager, logManager, bufferManager := openTestDb(t, dir)
+	blk1 := fm.NewBlockId("test_file", 1)
+	blk2 := fm.NewBlockId("test_file", 2)
+
+	tx1 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx1.Pin(blk1))
+	require.Nil(t, tx1.SetInt(blk1, 80, 42, true))
+	require.Nil(t, tx1.SetString(blk1, 100, "committed", true))
+	tx1.Commit()
+	// NO_FORCE策略下提交只写日志，数据页面还在缓存中
+	require.Equal(t, uint64(0), readDisk(t, fileManager, blk1).GetInt(80))
+
+	tx2 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx2.Pin(blk2))
+	require.Nil(t, tx2.SetInt(blk2, 80, 7, true))
+	// 没有提交的修改被置换出缓存写入了磁盘
+	bufferManager.FlushAll(tx2.txNum)
+	require.Equal(t, uint64(7), readDisk(t, fileManager, blk2).GetInt(80))
+
+	// 崩溃之后重启，恢复时重做tx1的修改并回滚tx2的修改
+	fileManager, logManager, bufferManager = openTestDb(t, dir)
+	tx3 := NewTransaction(fileManager, logManager, bufferManager)
+	tx3.Recover()
+	p := readDisk(t, fileManager, blk1)
+	require.Equal(t, uint64(42), p.GetInt(80))
+	require.Equal(t, "committed", p.GetString(100))
+	require.Equal(t, uint64(0), readDisk(t, fileManager, blk2).GetInt(80))
+}
+
+func TestRecoveryManager_Force(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "recovery")
+	fileManager, logManager, bufferManager := openTestDb(t, dir)
+	SetForcePolicy(logManager, FORCE)
+	blk := fm.NewBlockId("test_file", 1)
+
+	tx1 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx1.Pin(blk))
+	require.Nil(t, tx1.SetInt(blk, 80, 42, true))
+	tx1.Commit()
+	// FORCE策略下提交时数据页面已经写入磁盘，恢复时不需要重做
+	require.Equal(t, uint64(42), readDisk(t, fileManager, blk).GetInt(80))
+
+	// 把磁盘上的页面清空，恢复时不重做已经提交的事务，修改不会被写回来
+	_, err := fileManager.Write(blk, fm.NewPageBySize(400))
+	require.Nil(t, err)
+	fileManager, logManager, bufferManager = openTestDb(t, dir)
+	SetForcePolicy(logManager, FORCE)
+	tx2 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx2.Recover())
+	require.Equal(t, uint64(0), readDisk(t, fileManager, blk).GetInt(80))
+}
+
+// crashAfter 返回一个写入钩子，前n次写入正常执行，之后的写入全部失败，模拟在第n次写入之后崩溃
+func crashAfter(n int, writes *int) fm.WriteHook {
+	return func(blk *fm.BlockId) error {
+		*writes += 1
+		if *writes > n {
+			return errors.New("simulated crash")
+		}
+		return nil
+	}
+}
+
+/*
+crashWorkload 用很小的缓存池执行固定的事务序列，第i个事务把区块1到区块4的80处都写成i，并在160处写入长字符串，
+修改之后马上unpin并访问其他区块，让没有提交的修改也被置换出去写入磁盘，每个事务执行到一半时写入检查点。
+tx1和tx2提交，tx3回滚，tx4没有结束。返回每个事务提交之后一共执行过的写入次数，以及总的写入次数。
+崩溃之后的写入都会失败，这里不检查这些操作的错误
+*/
+func crashWorkload(dir string, crashAt int, policy ForcePolicy) (map[int]int, int) {
+	fileManager, _ := fm.NewFileManager(dir, 400)
+	writes := 0
+	fileManager.SetWriteHook(crashAfter(crashAt, &writes))
+	committed := make(map[int]int)
+	logManager, err := lm.NewLogManager(fileManager, "logfile")
+	if err != nil {
+		// 创建日志文件时就崩溃了
+		return committed, writes
+	}
+	SetForcePolicy(logManager, policy)
+	// 崩溃之后回滚失败的事务继续持有锁，后面的事务不必等满默认的时间
+	LockTableFor(logManager).SetTimeout(10 * time.Millisecond)
+	bufferManager := bm.NewBufferManager(fileManager, logManager, 3)
+
+	for i := 1; i <= 4; i++ {
+		tx := NewTransaction(fileManager, logManager, bufferManager)
+		for blkNum := uint64(1); blkNum <= 4; blkNum++ {
+			blk := fm.NewBlockId("test_file", blkNum)
+			tx.Pin(blk)
+			tx.SetInt(blk, 80, int64(i), true)
+			tx.SetString(blk, 100, fmt.Sprintf("value%d", i), true)
+			// 新旧两个长字符串几乎占满一条日志，回滚和恢复时的补偿日志同样要放得下
+			tx.SetString(blk, 160, longValue(i), true)
+			tx.Unpin(blk)
+			if blkNum == 2 {
+				// 事务执行到一半时写入非静止检查点
+				Checkpoint(logManager, bufferManager)
+			}
+		}
+		switch i {
+		case 3:
+			tx.Rollback()
+		case 4:
+		default:
+			tx.Commit()
+			committed[i] = writes
+		}
+	}
+	return committed, writes
+}
+
+// longValue 第i个事务写入的长字符串
+func longValue(i int) string {
+	return strings.Repeat(fmt.Sprint(i), 150)
+}
+
+// recoverDb 重启数据库执行恢复，crashAt不小于0时在恢复过程中的第crashAt次写入之后崩溃，返回恢复是否被打断
+func recoverDb(dir string, crashAt int, policy ForcePolicy) bool {
+	fileManager, _ := fm.NewFileManager(dir, 400)
+	writes := 0
+	if crashAt >= 0 {
+		fileManager.SetWriteHook(crashAfter(crashAt, &writes))
+	}
+	logManager, err := lm.NewLogManager(fileManager, "logfile")
+	if err != nil {
+		return true
+	}
+	dropDatabase(logManager)
+	SetForcePolicy(logManager, policy)
+	bufferManager := bm.NewBufferManager(fileManager, logManager, 3)
+	NewTransaction(fileManager, logManager, bufferManager).Recover()
+	return crashAt >= 0 && writes > crashAt
+}
+
+func copyDir(t *testing.T, src string, dst string) {
+	require.Nil(t, os.MkdirAll(dst, 0755))
+	entries, err := os.ReadDir(src)
+	require.Nil(t, err)
+	for _, entry := range entries {
+		bytes, err := os.ReadFile(filepath.Join(src, entry.Name()))
+		require.Nil(t, err)
+		require.Nil(t, os.WriteFile(filepath.Join(dst, entry.Name()), bytes, 0644))
+	}
+}
+
+func TestRecoveryManager_CrashAtEveryWrite(t *testing.T) {
+	checkCrashRecovery(t, NO_FORCE, 1)
+}
+
+// FORCE策略下恢复不重做已经提交的事务，每隔几次写入崩溃一次，控制测试的时间
+func TestRecoveryManager_ForceCrash(t *testing.T) {
+	checkCrashRecovery(t, FORCE, 3)
+}
+
+// checkCrashRecovery 每隔step次写入模拟一次崩溃，恢复之后只能看到崩溃之前最后一个提交的事务写入的值
+func checkCrashRecovery(t *testing.T, policy ForcePolicy, step int) {
+	_, total := crashWorkload(filepath.Join(t.TempDir(), "full"), math.MaxInt32, policy)
+	require.Greater(t, total, 0)
+	for crashAt := 0; crashAt <= total; crashAt += step {
+		crashed := filepath.Join(t.TempDir(), fmt.Sprintf("crash%d", crashAt))
+		committed, _ := crashWorkload(crashed, crashAt, policy)
+		// 提交时的日志写入在崩溃之前完成的最后一个事务
+		expected := 0
+		for i, writes := range committed {
+			if writes <= crashAt && i > expected {
+				expected = i
+			}
+		}
+
+		// 恢复过程中也可能在任意一次写入之后再次崩溃
+		for recoverAt := 0; ; recoverAt++ {
+			dir := filepath.Join(t.TempDir(), fmt.Sprintf("crash%d_%d", crashAt, recoverAt))
+			copyDir(t, crashed, dir)
+			interrupted := recoverDb(dir, recoverAt, policy)
+			recoverDb(dir, -1, policy)
+
+			fileManager, err := fm.NewFileManager(dir, 400)
+			require.Nil(t, err)
+			for blkNum := uint64(1); blkNum <= 4; blkNum++ {
+				p := readDisk(t, fileManager, fm.NewBlockId("test_file", blkNum))
+				require.Equal(t, uint64(expected), p.GetInt(80), "crash at %d, recovery crash at %d", crashAt, recoverAt)
+				if expected > 0 {
+					require.Equal(t, fmt.Sprintf("value%d", expected), p.GetString(100))
+					require.Equal(t, longValue(expected), p.GetString(160))
+				} else {
+					require.Equal(t, "", p.GetString(160))
+				}
+			}
+			if !interrupted {
+				break
+			}
+		}
+	}
+}
+
+func TestRecoveryManager_RollbackFollowsChain(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "recovery")
+	fileManager, logManager, bufferManager := openTestDb(t, dir)
+	blk1 := fm.NewBlockId("test_file", 1)
+	blk2 := fm.NewBlockId("test_file", 2)
+
+	tx1 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx1.Pin(blk1))
+	require.Nil(t, tx1.SetInt(blk1, 80, 1, true))
+	tx2 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx2.Pin(blk2))
+	for i := 0; i < 100; i++ {
+		require.Nil(t, tx2.SetInt(blk2, 80, int64(i), true))
+	}
+	require.Nil(t, tx1.SetInt(blk1, 120, 1, true))
+
+	// 把中间一个只有tx2日志的区块写坏，回滚tx1时沿着它自己的日志链直接读取，不会读到这个区块
+	lastBlk, _ := lm.LocationOf(400, tx1.recoveryManager.LastLSN())
+	require.Greater(t, lastBlk, uint64(5))
+	broken := fm.NewPageBySize(400)
+	broken.SetInt(0, 300)
+	broken.SetBytes(300, []byte{99})
+	_, err := fileManager.Write(fm.NewBlockId("logfile", 5), broken)
+	require.Nil(t, err)
+
+	tx1.Rollback()
+	tx3 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx3.Pin(blk1))
+	val, err := tx3.GetInt(blk1, 80)
+	require.Nil(t, err)
+	require.Equal(t, uint64(0), val)
+	val, err = tx3.GetInt(blk1, 120)
+	require.Nil(t, err)
+	require.Equal(t, uint64(0), val)
+}
+
+func TestRecoveryManager_OldLogFormat(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "recovery")
+	fileManager, logManager, bufferManager := openTestDb(t, dir)
+
+	// 旧格式的SETINT: <SETINT, txNum, fileName, blkNum, offset, oldVal>，没有prevLSN和新值
+	p := fm.NewPageBySize(6*UINT64_LENGTH + uint64(len("test_file")))
+	p.SetInt(0, uint64(SETINT_V1))
+	p.SetInt(UINT64_LENGTH, 1)
+	p.SetString(2*UINT64_LENGTH, "test_file")
+	pos := 2*UINT64_LENGTH + p.MaxLengthForString("test_file")
+	p.SetInt(pos, 1)
+	p.SetInt(pos+UINT64_LENGTH, 80)
+	p.SetInt(pos+2*UINT64_LENGTH, 7)
+	_, err := logManager.Append(p.GetRawBytes(0, p.Size()))
+	require.Nil(t, err)
+	require.Nil(t, logManager.Flush())
+
+	_, err = DecodeLogRecord(nil, p.GetRawBytes(0, p.Size()))
+	require.True(t, errors.Is(err, ErrOldLogFormat))
+	// 恢复时直接报错，不会把旧数据当作新格式重做或者撤销
+	tx := NewTransaction(fileManager, logManager, bufferManager)
+	err = tx.Recover()
+	require.True(t, errors.Is(err, ErrOldLogFormat), "%v", err)
+	require.Equal(t, uint64(0), readDisk(t, fileManager, fm.NewBlockId("test_file", 1)).GetInt(80))
+}
+
+func TestRecoveryManager_LogicalUndo(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "recovery")
+	fileManager, logManager, bufferManager := openTestDb(t, dir)
+	blk := fm.NewBlockId("test_file", 1)
+
+	tx1 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx1.Pin(blk))
+	require.Nil(t, tx1.SetInt(blk, 80, 10, true))
+	require.Nil(t, tx1.Commit())
+
+	// 回滚时通过事务执行逻辑日志的撤销，并为它写入补偿日志
+	tx2 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx2.Pin(blk))
+	addInt(t, tx2, blk, 80, 5)
+	require.Nil(t, tx2.SetInt(blk, 120, 7, true))
+	require.Nil(t, tx2.Rollback())
+	iterator := logManager.Iterator()
+	compensated := false
+	for iterator.HasNext() {
+		logRecord, err := DecodeLogRecord(nil, iterator.Next())
+		require.Nil(t, err)
+		if clr, ok := logRecord.(*CompensationRecord); ok && clr.Block() == nil {
+			compensated = true
+		}
+	}
+	require.True(t, compensated)
+
+	// 没有结束的事务的逻辑日志在恢复时撤销
+	tx3 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx3.Pin(blk))
+	val, err := tx3.GetInt(blk, 80)
+	require.Nil(t, err)
+	require.Equal(t, uint64(10), val)
+	val, err = tx3.GetInt(blk, 120)
+	require.Nil(t, err)
+	require.Equal(t, uint64(0), val)
+	addInt(t, tx3, blk, 80, 5)
+	require.Nil(t, bufferManager.FlushAll(tx3.txNum))
+	require.Nil(t, logManager.Flush())
+	require.Equal(t, uint64(15), readDisk(t, fileManager, blk).GetInt(80))
+
+	fileManager, logManager, bufferManager = openTestDb(t, dir)
+	require.Nil(t, NewTransaction(fileManager, logManager, bufferManager).Recover())
+	require.Equal(t, uint64(10), readDisk(t, fileManager, blk).GetInt(80))
+}
+
+func TestRecoveryManager_SetBytes(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "recovery")
+	fileManager, logManager, bufferManager := openTestDb(t, dir)
+	blk1 := fm.NewBlockId("test_file", 1)
+	blk2 := fm.NewBlockId("test_file", 2)
+
+	tx1 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx1.Pin(blk1))
+	require.Nil(t, tx1.SetBytes(blk1, 40, []byte("committed"), true))
+	require.Nil(t, tx1.SetFloat64(blk1, 80, 1.5, true))
+	require.Nil(t, tx1.Commit())
+
+	tx2 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx2.Pin(blk2))
+	require.Nil(t, tx2.SetBytes(blk2, 40, []byte("uncommitted"), true))
+	require.Nil(t, bufferManager.FlushAll(tx2.txNum))
+
+	// 崩溃之后重做tx1的字节修改，撤销tx2已经写入磁盘的修改
+	fileManager, logManager, bufferManager = openTestDb(t, dir)
+	require.Nil(t, NewTransaction(fileManager, logManager, bufferManager).Recover())
+	p := readDisk(t, fileManager, blk1)
+	require.Equal(t, "committed", string(p.GetRawBytes(40, 9)))
+	require.Equal(t, 1.5, p.GetFloat64(80))
+	require.Equal(t, make([]byte, 11), readDisk(t, fileManager, blk2).GetRawBytes(40, 11))
+}
@@ -10,6 +10,18 @@ type RollBackRecord struct {
 	txNum uint64
 }
 
+func init() {
+	mustRegisterLogRecord(ROLLBACK, LogRecordHandler{
+		Name: "ROLLBACK",
+		Decode: func(_ *lg.LogManager, p *fm.Page) (LogRecordInterface, error) {
+			if err := checkRecordSize(p, ROLLBACK, 2*UINT64_LENGTH); err != nil {
+				return nil, err
+			}
+			return NewRollBackRecord(p), nil
+		},
+	})
+}
+
 func NewRollBackRecord(p *fm.Page) *RollBackRecord {
 	return &RollBackRecord{
 		txNum: p.GetInt(UINT64_LENGTH),
@@ -24,10 +36,6 @@ func (r *RollBackRecord) TxNumber() uint64 {
 	return r.txNum
 }
 
-func (r *RollBackRecord) Undo(tx TransactionInterface) {
-	// 没有回滚操作
-}
-
 func (r *RollBackRecord) ToString() string {
 	return fmt.Sprintf("<ROLLBACK %d>", r.txNum)
 }
@@ -0,0 +1,74 @@
+package transaction_manager
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrNoSavepoint = errors.New("savepoint does not exist")
+
+// savepoint 保存点记录设置时事务最近写入的日志，回滚到保存点就是撤销这条日志之后的修改
+type savepoint struct {
+	name     string
+	lsn      uint64
+	versions int // 设置时事务在版本存储中修改过的数据个数，回滚时删除之后的版本
+}
+
+// Savepoint 在事务的当前位置设置保存点，已经存在的同名保存点会被移到当前位置
+func (t *Transaction) Savepoint(name string) error {
+	if err := t.checkActive(); err != nil {
+		return err
+	}
+	if i := t.findSavepoint(name); i >= 0 {
+		t.savepoints = append(t.savepoints[:i], t.savepoints[i+1:]...)
+	}
+	t.savepoints = append(t.savepoints, savepoint{
+		name:     name,
+		lsn:      t.recoveryManager.LastLSN(),
+		versions: t.versions.Mark(t.txNum),
+	})
+	return nil
+}
+
+/*
+RollbackTo 撤销保存点之后的修改，事务继续执行。撤销时写入补偿日志，之后崩溃或者整体回滚都不会再撤销一次。
+事务持有的锁和pin的页面保持不变，保存点本身保留，在它之后设置的保存点被删除。
+撤销的数据在版本存储中的版本也被删除，其他快照隔离的事务之后修改它们不会发生写冲突
+*/
+func (t *Transaction) RollbackTo(name string) error {
+	if err := t.checkActive(); err != nil {
+		return err
+	}
+	i := t.findSavepoint(name)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrNoSavepoint, name)
+	}
+	if err := t.recoveryManager.RollbackTo(t.savepoints[i].lsn); err != nil {
+		return err
+	}
+	t.versions.AbortTo(t.txNum, t.savepoints[i].versions)
+	t.savepoints = t.savepoints[:i+1]
+	return nil
+}
+
+// Release 删除保存点以及在它之后设置的保存点，已经做的修改不受影响
+func (t *Transaction) Release(name string) error {
+	if err := t.checkActive(); err != nil {
+		return err
+	}
+	i := t.findSavepoint(name)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrNoSavepoint, name)
+	}
+	t.savepoints = t.savepoints[:i]
+	return nil
+}
+
+func (t *Transaction) findSavepoint(name string) int {
+	for i, sp := range t.savepoints {
+		if sp.name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,95 @@
+package transaction_manager
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	fm "simpleDb/file_manager"
+	"testing"
+)
+
+func TestTransaction_RollbackToSavepoint(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "savepoint")
+	fileManager, logManager, bufferManager := openTestDb(t, dir)
+	blk1 := fm.NewBlockId("test_file", 1)
+	blk2 := fm.NewBlockId("test_file", 2)
+
+	tx := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx.Pin(blk1))
+	require.Nil(t, tx.Pin(blk2))
+	require.Nil(t, tx.SetInt(blk1, 80, 1, true))
+	require.Nil(t, tx.Savepoint("a"))
+	require.Nil(t, tx.SetInt(blk1, 80, 2, true))
+	require.Nil(t, tx.SetString(blk2, 80, "after a", true))
+	require.Nil(t, tx.Savepoint("b"))
+	require.Nil(t, tx.SetInt(blk2, 200, 3, true))
+
+	require.Nil(t, tx.RollbackTo("a"))
+	val, err := tx.GetInt(blk1, 80)
+	require.Nil(t, err)
+	require.Equal(t, uint64(1), val)
+	str, err := tx.GetString(blk2, 80)
+	require.Nil(t, err)
+	require.Equal(t, "", str)
+	// b在a之后设置，已经被删除
+	require.True(t, errors.Is(tx.RollbackTo("b"), ErrNoSavepoint))
+
+	// 回滚之后事务继续执行，页面仍然pin着，可以再次回滚到同一个保存点
+	require.Nil(t, tx.SetInt(blk2, 200, 4, true))
+	require.Nil(t, tx.RollbackTo("a"))
+	require.Nil(t, tx.SetInt(blk2, 200, 5, true))
+	require.Nil(t, tx.Release("a"))
+	require.True(t, errors.Is(tx.Release("a"), ErrNoSavepoint))
+	tx.Commit()
+
+	// 崩溃之后恢复，保存点之后撤销过的修改不会被重新做出来
+	fileManager, logManager, bufferManager = openTestDb(t, dir)
+	NewTransaction(fileManager, logManager, bufferManager).Recover()
+	require.Equal(t, uint64(1), readDisk(t, fileManager, blk1).GetInt(80))
+	p := readDisk(t, fileManager, blk2)
+	require.Equal(t, "", p.GetString(80))
+	require.Equal(t, uint64(5), p.GetInt(200))
+}
+
+func TestTransaction_SavepointThenCrash(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "savepoint")
+	fileManager, logManager, bufferManager := openTestDb(t, dir)
+	blk := fm.NewBlockId("test_file", 1)
+
+	tx := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx.Pin(blk))
+	require.Nil(t, tx.SetInt(blk, 80, 1, true))
+	require.Nil(t, tx.Savepoint("a"))
+	require.Nil(t, tx.SetInt(blk, 80, 2, true))
+	require.Nil(t, tx.SetInt(blk, 120, 2, true))
+	require.Nil(t, tx.RollbackTo("a"))
+	// 部分回滚的页面已经写入磁盘，事务没有结束就崩溃了，恢复时整个事务都要撤销
+	bufferManager.FlushAll(tx.txNum)
+	require.Nil(t, logManager.Flush())
+
+	fileManager, logManager, bufferManager = openTestDb(t, dir)
+	NewTransaction(fileManager, logManager, bufferManager).Recover()
+	p := readDisk(t, fileManager, blk)
+	require.Equal(t, uint64(0), p.GetInt(80))
+	require.Equal(t, uint64(0), p.GetInt(120))
+}
+
+func TestTransaction_RollbackToNoWriteConflict(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 8)
+	blk := fm.NewBlockId("test_file", 1)
+
+	tx1 := NewTransaction(fileManager, logManager, bufferManager)
+	tx2 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx1.Pin(blk))
+	require.Nil(t, tx2.Pin(blk))
+	require.Nil(t, tx1.SetInt(blk, 88, 1, true))
+	require.Nil(t, tx1.Savepoint("a"))
+	require.Nil(t, tx1.SetInt(blk, 80, 2, true))
+	require.Nil(t, tx1.RollbackTo("a"))
+	require.Nil(t, tx1.Commit())
+
+	// tx1对80的修改已经撤销，tx2的快照比tx1早，修改80不冲突
+	require.Nil(t, tx2.SetInt(blk, 80, 3, true))
+	// 88在保存点之前修改，仍然冲突
+	require.True(t, errors.Is(tx2.SetInt(blk, 88, 4, true), ErrSerialization))
+}
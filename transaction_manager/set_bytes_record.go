@@ -0,0 +1,152 @@
+package transaction_manager
+
+import (
+	"fmt"
+	fm "simpleDb/file_manager"
+	lm "simpleDb/log_manager"
+)
+
+/*
+SetBytesRecord 记录一段字节修改前后的内容，<SETBYTES, txNum, prevLSN, fileName, blkNum, offset, oldBytes, newBytes>。
+写日志之前去掉新旧数据相同的开头和结尾，日志中只保存实际改变的部分，oldBytes和newBytes的长度相同
+*/
+type SetBytesRecord struct {
+	txNum   uint64
+	prevLSN uint64 // 同一个事务的上一条日志，0表示没有
+	offset  uint64
+	val     []byte // 修改之前的数据，回滚时使用
+	newVal  []byte // 修改之后的数据，重做时使用
+	blk     *fm.BlockId
+}
+
+func init() {
+	mustRegisterLogRecord(SETBYTES, LogRecordHandler{
+		Name: "SETBYTES",
+		Decode: func(_ *lm.LogManager, p *fm.Page) (LogRecordInterface, error) {
+			rec, err := NewSetBytesRecord(p)
+			if err != nil {
+				return nil, err
+			}
+			return rec, nil
+		},
+		Undo: func(rec LogRecordInterface, tx TransactionInterface) error {
+			return rec.(*SetBytesRecord).Undo(tx)
+		},
+		Redo: func(rec LogRecordInterface, tx TransactionInterface) error {
+			return rec.(*SetBytesRecord).Redo(tx)
+		},
+	})
+}
+
+func NewSetBytesRecord(p *fm.Page) (*SetBytesRecord, error) {
+	r := newRecordReader(p, SETBYTES)
+	txNum := r.readInt("txNum")
+	prevLSN := r.readInt("prevLSN")
+	fileName := r.readString("fileName")
+	blkNum := r.readInt("blkNum")
+	offset := r.readInt("offset")
+	val := r.readBytes("oldBytes")
+	newVal := r.readBytes("newBytes")
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return &SetBytesRecord{
+		txNum:   txNum,
+		prevLSN: prevLSN,
+		offset:  offset,
+		val:     val,
+		newVal:  newVal,
+		blk:     fm.NewBlockId(fileName, blkNum),
+	}, nil
+}
+
+func (s *SetBytesRecord) Op() RECORD_TYPE {
+	return SETBYTES
+}
+
+func (s *SetBytesRecord) TxNumber() uint64 {
+	return s.txNum
+}
+
+func (s *SetBytesRecord) PrevLSN() uint64 {
+	return s.prevLSN
+}
+
+func (s *SetBytesRecord) Block() *fm.BlockId {
+	return s.blk
+}
+
+func (s *SetBytesRecord) Offset() uint64 {
+	return s.offset
+}
+
+func (s *SetBytesRecord) OldValue() []byte {
+	return s.val
+}
+
+func (s *SetBytesRecord) NewValue() []byte {
+	return s.newVal
+}
+
+func (s *SetBytesRecord) ToString() string {
+	return fmt.Sprintf("<SETBYTES %d %d %d %x %x>", s.txNum, s.blk.Number(), s.offset, s.val, s.newVal)
+}
+
+func (s *SetBytesRecord) Undo(tx TransactionInterface) error {
+	if err := tx.Pin(s.blk); err != nil {
+		return err
+	}
+	defer tx.Unpin(s.blk)
+	return tx.SetBytes(s.blk, s.offset, s.val, false) // 将原来的数据写回去
+}
+
+func (s *SetBytesRecord) Redo(tx TransactionInterface) error {
+	if err := tx.Pin(s.blk); err != nil {
+		return err
+	}
+	defer tx.Unpin(s.blk)
+	return tx.SetBytes(s.blk, s.offset, s.newVal, false) // 将修改后的数据重新写入
+}
+
+// setBytesOverhead 除去新旧数据本身，一条SETBYTES日志占用的字节数。补偿日志的开销相同但是只有一份数据，不会比SETBYTES日志长
+func setBytesOverhead(blk *fm.BlockId) uint64 {
+	p := fm.NewPageBySize(1)
+	return 5*UINT64_LENGTH + p.MaxLengthForString(blk.FileName()) + 2*UINT64_LENGTH
+}
+
+// changedRange 返回新旧数据不同的部分[start, end)，数据完全相同时start等于end
+func changedRange(oldVal []byte, newVal []byte) (uint64, uint64) {
+	start, end := 0, len(newVal)
+	for start < end && oldVal[start] == newVal[start] {
+		start++
+	}
+	for end > start && oldVal[end-1] == newVal[end-1] {
+		end--
+	}
+	return uint64(start), uint64(end)
+}
+
+func WriteSetBytesLog(logManager *lm.LogManager, txNum uint64, prevLSN uint64, blk *fm.BlockId, offset uint64, val []byte, newVal []byte) (uint64, error) {
+	tPos := uint64(UINT64_LENGTH)
+	pPos := tPos + UINT64_LENGTH
+	fPos := pPos + UINT64_LENGTH
+	p := fm.NewPageBySize(1)
+	bPos := fPos + p.MaxLengthForString(blk.FileName())
+	oPos := bPos + UINT64_LENGTH
+	vPos := oPos + UINT64_LENGTH
+	nPos := vPos + UINT64_LENGTH + uint64(len(val))
+	rec := make([]byte, nPos+UINT64_LENGTH+uint64(len(newVal)))
+
+	p = fm.NewPageByBytes(rec)
+	p.SetInt(0, uint64(SETBYTES))
+	p.SetInt(tPos, txNum)
+	p.SetInt(pPos, prevLSN)
+	p.SetString(fPos, blk.FileName())
+	p.SetInt(bPos, blk.Number())
+	p.SetInt(oPos, offset)
+	p.SetBytes(vPos, val)
+	p.SetBytes(nPos, newVal)
+
+	return logManager.Append(rec)
+}
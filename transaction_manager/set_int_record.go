@@ -6,70 +6,133 @@ import (
 	lm "simpleDb/log_manager"
 )
 
+// SetIntRecord 记录修改前后的值，<SETINT, txNum, prevLSN, fileName, blkNum, offset, oldVal, newVal>
 type SetIntRecord struct {
-	txNum  uint64
-	offset uint64
-	val    uint64
-	blk    *fm.BlockId
+	txNum   uint64
+	prevLSN uint64 // 同一个事务的上一条日志，0表示没有
+	offset  uint64
+	val     uint64 // 修改之前的值，回滚时使用
+	newVal  uint64 // 修改之后的值，重做时使用
+	blk     *fm.BlockId
 }
 
-func NewSetIntRecord(p *fm.Page) *SetIntRecord {
-	tPos := uint64(UINT64_LENGTH)
-	txNum := p.GetInt(tPos)
-	fPos := tPos + UINT64_LENGTH
-	fileName := p.GetString(fPos)
-	bPos := fPos + p.MaxLengthForString(fileName)
-	blkNum := p.GetInt(bPos)
-	blk := fm.NewBlockId(fileName, blkNum)
-	opos := bPos + UINT64_LENGTH
-	offset := p.GetInt(opos)
-	vPos := opos + UINT64_LENGTH
-	val := p.GetInt(vPos)
+func init() {
+	mustRegisterLogRecord(SETINT, LogRecordHandler{
+		Name: "SETINT",
+		Decode: func(_ *lm.LogManager, p *fm.Page) (LogRecordInterface, error) {
+			rec, err := NewSetIntRecord(p)
+			if err != nil {
+				return nil, err
+			}
+			return rec, nil
+		},
+		Undo: func(rec LogRecordInterface, tx TransactionInterface) error {
+			return rec.(*SetIntRecord).Undo(tx)
+		},
+		Redo: func(rec LogRecordInterface, tx TransactionInterface) error {
+			return rec.(*SetIntRecord).Redo(tx)
+		},
+	})
+}
 
-	return &SetIntRecord{
-		txNum:  txNum,
-		offset: offset,
-		val:    val,
-		blk:    blk,
+func NewSetIntRecord(p *fm.Page) (*SetIntRecord, error) {
+	r := newRecordReader(p, SETINT)
+	txNum := r.readInt("txNum")
+	prevLSN := r.readInt("prevLSN")
+	fileName := r.readString("fileName")
+	blkNum := r.readInt("blkNum")
+	offset := r.readInt("offset")
+	val := r.readInt("oldVal")
+	newVal := r.readInt("newVal")
+	if r.err != nil {
+		return nil, r.err
 	}
+
+	return &SetIntRecord{
+		txNum:   txNum,
+		prevLSN: prevLSN,
+		offset:  offset,
+		val:     val,
+		newVal:  newVal,
+		blk:     fm.NewBlockId(fileName, blkNum),
+	}, nil
 }
 
 func (s *SetIntRecord) Op() RECORD_TYPE {
-	return SETSTRING
+	return SETINT
 }
 
 func (s *SetIntRecord) TxNumber() uint64 {
 	return s.txNum
 }
 
+func (s *SetIntRecord) PrevLSN() uint64 {
+	return s.prevLSN
+}
+
+func (s *SetIntRecord) Block() *fm.BlockId {
+	return s.blk
+}
+
+func (s *SetIntRecord) Offset() uint64 {
+	return s.offset
+}
+
+func (s *SetIntRecord) OldValue() uint64 {
+	return s.val
+}
+
+func (s *SetIntRecord) NewValue() uint64 {
+	return s.newVal
+}
+
 func (s *SetIntRecord) ToString() string {
-	str := fmt.Sprintf("<SETINT %d %d %d %d>", s.txNum, s.blk.Number(), s.offset, s.val)
+	str := fmt.Sprintf("<SETINT %d %d %d %d %d>", s.txNum, s.blk.Number(), s.offset, s.val, s.newVal)
 	return str
 }
 
-func (s *SetIntRecord) Undo(tx TransactionInterface) {
-	tx.Pin(s.blk)
-	tx.SetInt(s.blk, s.offset, int64(s.val), false) // 将原来的字符串写回去
-	tx.Unpin(s.blk)
+func (s *SetIntRecord) Undo(tx TransactionInterface) error {
+	if err := tx.Pin(s.blk); err != nil {
+		return err
+	}
+	defer tx.Unpin(s.blk)
+	return tx.SetInt(s.blk, s.offset, int64(s.val), false) // 将原来的数据写回去
+}
+
+func (s *SetIntRecord) Redo(tx TransactionInterface) error {
+	if err := tx.Pin(s.blk); err != nil {
+		return err
+	}
+	defer tx.Unpin(s.blk)
+	return tx.SetInt(s.blk, s.offset, int64(s.newVal), false) // 将修改后的数据重新写入
+}
+
+// setIntLogSize 一条SETINT日志的字节数，只和文件名的长度有关
+func setIntLogSize(blk *fm.BlockId) uint64 {
+	p := fm.NewPageBySize(1)
+	return 7*UINT64_LENGTH + p.MaxLengthForString(blk.FileName())
 }
 
-func WriteSetIntLog(logManager *lm.LogManager, txNum uint64, blk *fm.BlockId, offset uint64, val uint64) (uint64, error) {
+func WriteSetIntLog(logManager *lm.LogManager, txNum uint64, prevLSN uint64, blk *fm.BlockId, offset uint64, val uint64, newVal uint64) (uint64, error) {
 	tPos := uint64(UINT64_LENGTH)
-	fPos := uint64(tPos + UINT64_LENGTH)
+	pPos := uint64(tPos + UINT64_LENGTH)
+	fPos := uint64(pPos + UINT64_LENGTH)
 	p := fm.NewPageBySize(1)
 	bPos := uint64(fPos + p.MaxLengthForString(blk.FileName()))
 	oPos := uint64(bPos + UINT64_LENGTH)
 	vPos := uint64(oPos + UINT64_LENGTH)
-	recLen := uint64(vPos + UINT64_LENGTH)
-	rec := make([]byte, recLen)
+	nPos := uint64(vPos + UINT64_LENGTH)
+	rec := make([]byte, setIntLogSize(blk))
 
 	p = fm.NewPageByBytes(rec)
-	p.SetInt(0, uint64(SETSTRING))
+	p.SetInt(0, uint64(SETINT))
 	p.SetInt(tPos, txNum)
+	p.SetInt(pPos, prevLSN)
 	p.SetString(fPos, blk.FileName())
 	p.SetInt(bPos, blk.Number())
 	p.SetInt(oPos, offset)
 	p.SetInt(vPos, val)
+	p.SetInt(nPos, newVal)
 
 	return logManager.Append(rec)
 }
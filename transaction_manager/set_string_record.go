@@ -17,37 +17,56 @@ import (
 的前面，在回滚的时候我们会先读入joe，再读到apple，所以在读到相应的记录后就直接做相应的操作就可以了。
 */
 
+// SetStringRecord 记录修改前后的值，<SETSTRING, txNum, prevLSN, fileName, blkNum, offset, oldVal, newVal>
 type SetStringRecord struct {
-	val    string
-	txNum  uint64
-	blk    *fm.BlockId
-	offset uint64
+	val     string // 修改之前的值，回滚时使用
+	newVal  string // 修改之后的值，重做时使用
+	txNum   uint64
+	prevLSN uint64 // 同一个事务的上一条日志，0表示没有
+	blk     *fm.BlockId
+	offset  uint64
 }
 
-func NewSetStringRecord(p *fm.Page) *SetStringRecord {
-	// 获取事务号
-	txPos := uint64(UINT64_LENGTH)
-	txNum := p.GetInt(txPos)
-	// 获取文件名
-	fPos := txPos + uint64(UINT64_LENGTH)
-	fileName := p.GetString(fPos)
-	// 获取块号
-	blkPos := fPos + p.MaxLengthForString(fileName)
-	blkNum := p.GetInt(blkPos)
-	// 获取偏移的位置
-	offsetPos := blkPos + uint64(UINT64_LENGTH)
-	offset := p.GetInt(offsetPos)
-	// 获取数据
-	strPos := offsetPos + uint64(UINT64_LENGTH)
-	data := p.GetString(strPos)
-
-	blk := fm.NewBlockId(fileName, blkNum)
-	return &SetStringRecord{
-		val:    data,
-		txNum:  txNum,
-		blk:    blk,
-		offset: offset,
+func init() {
+	mustRegisterLogRecord(SETSTRING, LogRecordHandler{
+		Name: "SETSTRING",
+		Decode: func(_ *lm.LogManager, p *fm.Page) (LogRecordInterface, error) {
+			rec, err := NewSetStringRecord(p)
+			if err != nil {
+				return nil, err
+			}
+			return rec, nil
+		},
+		Undo: func(rec LogRecordInterface, tx TransactionInterface) error {
+			return rec.(*SetStringRecord).Undo(tx)
+		},
+		Redo: func(rec LogRecordInterface, tx TransactionInterface) error {
+			return rec.(*SetStringRecord).Redo(tx)
+		},
+	})
+}
+
+func NewSetStringRecord(p *fm.Page) (*SetStringRecord, error) {
+	r := newRecordReader(p, SETSTRING)
+	txNum := r.readInt("txNum")
+	prevLSN := r.readInt("prevLSN")
+	fileName := r.readString("fileName")
+	blkNum := r.readInt("blkNum")
+	offset := r.readInt("offset")
+	data := r.readString("oldVal")
+	newData := r.readString("newVal")
+	if r.err != nil {
+		return nil, r.err
 	}
+
+	return &SetStringRecord{
+		val:     data,
+		newVal:  newData,
+		txNum:   txNum,
+		prevLSN: prevLSN,
+		blk:     fm.NewBlockId(fileName, blkNum),
+		offset:  offset,
+	}, nil
 }
 
 func (s *SetStringRecord) Op() RECORD_TYPE {
@@ -58,37 +77,77 @@ func (s *SetStringRecord) TxNumber() uint64 {
 	return s.txNum
 }
 
+func (s *SetStringRecord) PrevLSN() uint64 {
+	return s.prevLSN
+}
+
+func (s *SetStringRecord) Block() *fm.BlockId {
+	return s.blk
+}
+
+func (s *SetStringRecord) Offset() uint64 {
+	return s.offset
+}
+
+func (s *SetStringRecord) OldValue() string {
+	return s.val
+}
+
+func (s *SetStringRecord) NewValue() string {
+	return s.newVal
+}
+
 func (s *SetStringRecord) ToString() string {
-	str := fmt.Sprintf("<SETSTRING %d %d %d %s>", s.txNum, s.blk.Number(), s.offset, s.val)
+	str := fmt.Sprintf("<SETSTRING %d %d %d %s %s>", s.txNum, s.blk.Number(), s.offset, s.val, s.newVal)
 	return str
 }
 
-func (s *SetStringRecord) Undo(tx TransactionInterface) {
-	tx.Pin(s.blk)
-	tx.SetString(s.blk, s.offset, s.val, false) // 将原来的数据写回去
-	tx.Unpin(s.blk)
+func (s *SetStringRecord) Undo(tx TransactionInterface) error {
+	if err := tx.Pin(s.blk); err != nil {
+		return err
+	}
+	defer tx.Unpin(s.blk)
+	return tx.SetString(s.blk, s.offset, s.val, false) // 将原来的数据写回去
+}
+
+func (s *SetStringRecord) Redo(tx TransactionInterface) error {
+	if err := tx.Pin(s.blk); err != nil {
+		return err
+	}
+	defer tx.Unpin(s.blk)
+	return tx.SetString(s.blk, s.offset, s.newVal, false) // 将修改后的数据重新写入
+}
+
+// setStringLogSize 一条SETSTRING日志的字节数
+func setStringLogSize(blk *fm.BlockId, val string, newVal string) uint64 {
+	p := fm.NewPageBySize(1)
+	return 5*UINT64_LENGTH + p.MaxLengthForString(blk.FileName()) + p.MaxLengthForString(val) + p.MaxLengthForString(newVal)
 }
 
 //WriteSetStringLog 构造字符串内容的日志，SetStringRecord在构造中默认给定缓冲区中已经有了字符串信息
 // 但是在初始化阶段，缓存页面可能还没有相应的日志信息，这个接口的作用就是为给定缓存写入日志内容
-func WriteSetStringLog(lm *lm.LogManager, txNum uint64, blk *fm.BlockId, offset uint64, val string) (uint64, error) {
+func WriteSetStringLog(lm *lm.LogManager, txNum uint64, prevLSN uint64, blk *fm.BlockId, offset uint64, val string, newVal string) (uint64, error) {
 	txNumPos := uint64(UINT64_LENGTH)
-	fileNamePos := uint64(txNumPos + UINT64_LENGTH)
+	prevLSNPos := uint64(txNumPos + UINT64_LENGTH)
+	fileNamePos := uint64(prevLSNPos + UINT64_LENGTH)
 	p := fm.NewPageBySize(1)
 	blkPost := uint64(fileNamePos + p.MaxLengthForString(blk.FileName()))
 	offsetPos := uint64(blkPost + UINT64_LENGTH)
 	valPos := uint64(offsetPos + UINT64_LENGTH)
 
-	recLen := uint64(valPos + p.MaxLengthForString(val))
-	rec := make([]byte, recLen)
+	newValPos := uint64(valPos + p.MaxLengthForString(val))
+
+	rec := make([]byte, setStringLogSize(blk, val, newVal))
 	// 将信息存到page中
 	p = fm.NewPageByBytes(rec)
 	p.SetInt(0, uint64(SETSTRING))
 	p.SetInt(txNumPos, txNum)
+	p.SetInt(prevLSNPos, prevLSN)
 	p.SetString(fileNamePos, blk.FileName())
 	p.SetInt(blkPost, blk.Number())
 	p.SetInt(offsetPos, offset)
 	p.SetString(valPos, val)
+	p.SetString(newValPos, newVal)
 	// 将记录添加到日志中
 	return lm.Append(rec)
 }
@@ -11,6 +11,18 @@ type StartRecord struct {
 	logManager *lm.LogManager
 }
 
+func init() {
+	mustRegisterLogRecord(START, LogRecordHandler{
+		Name: "START",
+		Decode: func(logManager *lm.LogManager, p *fm.Page) (LogRecordInterface, error) {
+			if err := checkRecordSize(p, START, 2*UINT64_LENGTH); err != nil {
+				return nil, err
+			}
+			return NewStartRecord(logManager, p), nil
+		},
+	})
+}
+
 func NewStartRecord(lm *lm.LogManager, p *fm.Page) *StartRecord {
 	// p 的开头8字节为事务的类型，之后是事务的id
 	txNum := p.GetInt(UINT64_LENGTH)
@@ -28,10 +40,6 @@ func (s *StartRecord) TxNumber() uint64 {
 	return s.txNum
 }
 
-func (s *StartRecord) Undo(tx TransactionInterface) {
-	// 什么也不做
-}
-
 func (s *StartRecord) ToString() string {
 	str := fmt.Sprintf("<START %d>", s.txNum)
 	return str
@@ -6,150 +6,535 @@ import (
 	bm "simpleDb/buffer_manager"
 	fm "simpleDb/file_manager"
 	lm "simpleDb/log_manager"
-	"sync"
 )
 
-var txNumMu sync.Mutex
-var nextTxNum = int32(0)
-
-func NextTxNum() int32 {
-	txNumMu.Lock()
-	defer txNumMu.Unlock()
-	nextTxNum = nextTxNum + 1
-	return nextTxNum
-}
+// PAGE_LSN_SIZE 每个数据页面末尾保留的字节数，用来记录最近一次修改页面的日志编号
+const PAGE_LSN_SIZE = UINT64_LENGTH
 
 type Transaction struct {
-	//同步管理器
+	concurMgr       *ConcurrencyManager // 同步管理器
 	recoveryManager *RecoveryManager
 	fileManager     *fm.FileManager
 	logManager      *lm.LogManager
 	bufferManager   *bm.BufferManager
 	myBuffers       *BufferList
-	txNum           int32
+	reservations    []*bm.Reservation // 事务结束时需要归还的预留页面
+	versions        *VersionStore
+	snapshot        uint64 // 快照时间戳，读数据时只能看到在它之前提交的修改
+	isolation       IsolationLevel
+	savepoints      []savepoint // 按照设置的先后顺序排列
+	state           TxState
+	undoing         bool            // 正在回滚，回滚中的逻辑撤销不受牺牲者标记的影响
+	onPinLeak       func(err error) // 事务结束时还有没unpin的页面就调用它
+	startErr        error           // 开始日志写入失败的原因，事务一创建就是FAILED
+	txNum           uint64
+}
+
+// WithPinLeakHandler 事务结束时如果还有页面没有unpin，把满足errors.Is(err, bm.ErrPinLeak)的错误交给handler
+func WithPinLeakHandler(handler func(err error)) TxOption {
+	return func(t *Transaction) {
+		t.onPinLeak = handler
+	}
 }
 
-func NewTransaction(fileManager *fm.FileManager, logManage *lm.LogManager, bufferManager *bm.BufferManager) *Transaction {
-	txNum := NextTxNum()
+// NewTransaction 创建事务，开始日志没能写入时事务处于FAILED状态，所有操作都返回满足errors.Is(err, ErrTxNotActive)的错误
+func NewTransaction(fileManager *fm.FileManager, logManage *lm.LogManager, bufferManager *bm.BufferManager, opts ...TxOption) *Transaction {
+	db := databaseOf(logManage)
+	txNum := db.txNums.next(fileManager, logManage)
 	tx := &Transaction{
 		fileManager:   fileManager,
 		logManager:    logManage,
 		bufferManager: bufferManager,
-		myBuffers:     NewBufferList(bufferManager),
+		myBuffers:     NewBufferList(bufferManager, fmt.Sprintf("tx %d", txNum)),
+		isolation:     REPEATABLE_READ,
+		snapshot:      NO_SNAPSHOT,
 		txNum:         txNum,
 	}
-	tx.recoveryManager = NewRecoveryManager(tx, txNum, logManage, bufferManager)
+	for _, opt := range opts {
+		opt(tx)
+	}
+	tx.concurMgr = NewConcurrencyManager(db.lockTable, txNum)
+	tx.versions = db.versions
+	if tx.isolation == REPEATABLE_READ {
+		// 只有快照隔离需要固定的快照，其他隔离级别不检查写冲突
+		tx.snapshot = db.versions.Begin(txNum)
+	}
+	recoveryManager, err := NewRecoveryManager(tx, txNum, logManage, bufferManager)
+	tx.recoveryManager = recoveryManager
+	if err != nil {
+		// 开始日志没有写入，事务不在活跃事务表中，之后的修改无法恢复，所有操作都返回这个错误
+		tx.state = FAILED
+		tx.startErr = err
+		db.versions.Abort(txNum)
+	}
 	return tx
 }
 
-func (t *Transaction) Commit() {
+/*
+Commit 提交事务。提交日志没能写入时回滚事务并返回错误；提交日志写入了缓冲区但是没能写入磁盘时，
+事务仍然算作提交，返回的错误满足errors.Is(err, ErrNotDurable)
+*/
+func (t *Transaction) Commit() error {
+	if err := t.checkState(); err != nil {
+		return err
+	}
+	if err := t.checkActive(); err != nil {
+		// 已经被选为牺牲者的事务不能提交，checkActive已经回滚了它
+		return fmt.Errorf("commit tx %d: %w", t.txNum, err)
+	}
+	t.state = COMMITTING
 	// 调用恢复管理器执行commit
-	t.recoveryManager.Commit()
-	fmt.Println(fmt.Sprintf("transaction %d commited", t.txNum))
-	// 释放同步管理器
+	err := t.recoveryManager.Commit()
+	if err != nil && !errors.Is(err, ErrNotDurable) {
+		t.state = ACTIVE
+		if rollbackErr := t.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("commit tx %d: %v, rollback: %w", t.txNum, err, rollbackErr)
+		}
+		return fmt.Errorf("commit tx %d: %w", t.txNum, err)
+	}
+	t.finish(COMMITTED)
+	if err != nil {
+		return fmt.Errorf("commit tx %d: %w", t.txNum, err)
+	}
+	return nil
+}
+
+/*
+Rollback 回滚事务。撤销失败时页面上还留着没有撤销的修改，事务进入FAILED状态，继续持有锁和版本，
+其他事务不会读到这些修改，剩下的修改在重启之后的恢复中撤销。回滚日志没能写入磁盘时事务仍然算作回滚，
+返回的错误满足errors.Is(err, ErrNotDurable)
+*/
+func (t *Transaction) Rollback() error {
+	if err := t.checkState(); err != nil {
+		return err
+	}
+	t.undoing = true
+	err := t.recoveryManager.Rollback()
+	t.undoing = false
+	if err != nil && !errors.Is(err, ErrNotDurable) {
+		t.fail()
+		return fmt.Errorf("rollback tx %d: %w", t.txNum, err)
+	}
+	t.finish(ABORTED)
+	if err != nil {
+		return fmt.Errorf("rollback tx %d: %w", t.txNum, err)
+	}
+	return nil
+}
+
+// fail 回滚没有完成，只释放预留和pin的页面，锁和版本保留到重启
+func (t *Transaction) fail() {
+	t.state = FAILED
+	t.releaseReservations()
+	t.reportPinLeaks()
 	t.myBuffers.UnpinAll()
 }
 
-func (t *Transaction) Rollback() {
-	t.recoveryManager.Rollback()
-	fmt.Println(fmt.Sprintf("transaction %d roll back", t.txNum))
+// finish 事务结束，释放锁、预留和pin的页面
+func (t *Transaction) finish(state TxState) {
+	t.state = state
+	if state == COMMITTED {
+		t.versions.Commit(t.txNum)
+		fmt.Println(fmt.Sprintf("transaction %d commited", t.txNum))
+	} else {
+		t.versions.Abort(t.txNum)
+		fmt.Println(fmt.Sprintf("transaction %d roll back", t.txNum))
+	}
 	// 释放同步管理器
+	t.concurMgr.Release()
+	t.releaseReservations()
+	// 在释放之前检查，还被pin着的页面就是调用者忘了unpin的页面
+	t.reportPinLeaks()
 	t.myBuffers.UnpinAll()
 }
 
-func (t *Transaction) Recover() {
-	// 系统启动时会在所有的交易执行之前执行该函数
-	t.bufferManager.FlushAll(t.txNum)
-	t.recoveryManager.Recover()
+// Reserve 为多缓存的查询算子预留n个页面，预留在事务提交或者回滚时自动归还
+func (t *Transaction) Reserve(n uint32) (*bm.Reservation, error) {
+	if err := t.checkActive(); err != nil {
+		return nil, err
+	}
+	reservation, err := t.bufferManager.ReserveAs(n, t.myBuffers.owner)
+	if err != nil {
+		return nil, err
+	}
+	t.reservations = append(t.reservations, reservation)
+	return reservation, nil
+}
+
+func (t *Transaction) releaseReservations() {
+	for _, reservation := range t.reservations {
+		reservation.Release()
+	}
+	t.reservations = nil
 }
 
-func (t *Transaction) Pin(blk *fm.BlockId) {
-	t.myBuffers.Pin(blk)
+func (t *Transaction) reportPinLeaks() {
+	if t.onPinLeak == nil {
+		return
+	}
+	if err := t.myBuffers.CheckLeaks(); err != nil {
+		t.onPinLeak(fmt.Errorf("tx %d: %w", t.txNum, err))
+	}
+}
+
+// Recover 系统启动时会在所有的交易执行之前执行该函数，恢复结束之后执行恢复的事务也随之结束
+func (t *Transaction) Recover() error {
+	if err := t.checkActive(); err != nil {
+		return err
+	}
+	err := t.bufferManager.FlushAll(t.txNum)
+	if err == nil {
+		err = t.recoveryManager.Recover()
+	}
+	if err != nil {
+		// 恢复被打断相当于又一次崩溃，下次启动时重新恢复
+		t.finish(ABORTED)
+		return fmt.Errorf("recover: %w", err)
+	}
+	t.finish(COMMITTED)
+	return nil
+}
+
+// Pin 返回bm.ErrBufferAbort时说明等不到可用的缓存页面，调用者应该回滚事务释放自己占用的页面
+func (t *Transaction) Pin(blk *fm.BlockId) error {
+	if err := t.checkActive(); err != nil {
+		return err
+	}
+	return t.myBuffers.Pin(blk)
 }
 
 func (t *Transaction) Unpin(blk *fm.BlockId) {
 	t.myBuffers.Unpin(blk)
 }
 
+// LockFile 在整个文件上加锁，全表扫描用S，修改表结构用X，读全表同时修改部分区块用SIX
+func (t *Transaction) LockFile(fileName string, mode LockMode) error {
+	if err := t.checkActive(); err != nil {
+		return err
+	}
+	if err := t.concurMgr.LockFile(fileName, mode); err != nil {
+		return t.abortIfVictim(err)
+	}
+	return nil
+}
+
+// abortIfVictim 事务被选为死锁的牺牲者或者发生写冲突时先回滚释放自己持有的锁，再把错误返回给调用者
+func (t *Transaction) abortIfVictim(err error) error {
+	if errors.Is(err, ErrDeadlock) || errors.Is(err, ErrSerialization) {
+		if rollbackErr := t.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w, rollback: %v", err, rollbackErr)
+		}
+	}
+	return err
+}
+
 func (t *Transaction) bufferNotExist(blk *fm.BlockId) error {
 	errMessage := fmt.Sprintf("no buffer found for given blk : %d with file name : %s\n", blk.Number(), blk.FileName())
 	return errors.New(errMessage)
 }
 
+func (t *Transaction) IsolationLevel() IsolationLevel {
+	return t.isolation
+}
+
 func (t *Transaction) GetInt(blk *fm.BlockId, offset uint64) (uint64, error) {
-	// 调用同步管理器加锁
-	buffer := t.myBuffers.GetBuffer(blk)
-	if buffer == nil {
-		return uint64(0), t.bufferNotExist(blk)
+	val, err := t.read(blk, offset, func(p *fm.Page) interface{} { return p.GetInt(offset) })
+	if err != nil {
+		return 0, err
 	}
-	return buffer.Contents().GetInt(offset), nil
+	return val.(uint64), nil
 }
 
 func (t *Transaction) GetString(blk *fm.BlockId, offset uint64) (string, error) {
-	// 调用同步管理器加锁
+	val, err := t.read(blk, offset, func(p *fm.Page) interface{} { return p.GetString(offset) })
+	if err != nil {
+		return "", err
+	}
+	return val.(string), nil
+}
+
+// GetBytes 读出从offset开始的length个字节
+func (t *Transaction) GetBytes(blk *fm.BlockId, offset uint64, length uint64) ([]byte, error) {
+	val, err := t.read(blk, offset, func(p *fm.Page) interface{} { return p.GetRawBytes(offset, length) })
+	if err != nil {
+		return nil, err
+	}
+	return val.([]byte), nil
+}
+
+func (t *Transaction) GetInt32(blk *fm.BlockId, offset uint64) (int32, error) {
+	val, err := t.read(blk, offset, func(p *fm.Page) interface{} { return p.GetInt32(offset) })
+	if err != nil {
+		return 0, err
+	}
+	return val.(int32), nil
+}
+
+func (t *Transaction) GetBool(blk *fm.BlockId, offset uint64) (bool, error) {
+	val, err := t.read(blk, offset, func(p *fm.Page) interface{} { return p.GetBool(offset) })
+	if err != nil {
+		return false, err
+	}
+	return val.(bool), nil
+}
+
+func (t *Transaction) GetFloat64(blk *fm.BlockId, offset uint64) (float64, error) {
+	val, err := t.read(blk, offset, func(p *fm.Page) interface{} { return p.GetFloat64(offset) })
+	if err != nil {
+		return 0, err
+	}
+	return val.(float64), nil
+}
+
+/*
+read 按照隔离级别读取数据。只有可串行化的事务读数据时加共享锁，其他隔离级别读数据不会阻塞写数据的事务:
+可重复读读取事务的快照，读已提交每次读取最新提交的值，读未提交直接返回页面上的值
+*/
+func (t *Transaction) read(blk *fm.BlockId, offset uint64, get func(p *fm.Page) interface{}) (interface{}, error) {
+	if err := t.checkActive(); err != nil {
+		return nil, err
+	}
+	if t.isolation == SERIALIZABLE {
+		if err := t.concurMgr.SLock(blk); err != nil {
+			return nil, t.abortIfVictim(err)
+		}
+	}
 	buffer := t.myBuffers.GetBuffer(blk)
 	if buffer == nil {
-		return "", t.bufferNotExist(blk)
+		return nil, t.bufferNotExist(blk)
+	}
+	buffer.LatchShared()
+	defer buffer.UnlatchShared()
+	current := get(buffer.Contents())
+	switch t.isolation {
+	case REPEATABLE_READ:
+		return t.versions.Read(t.txNum, t.snapshot, blk, offset, current), nil
+	case READ_COMMITTED:
+		return t.versions.ReadCommitted(t.txNum, blk, offset, current), nil
 	}
-	return buffer.Contents().GetString(offset), nil
+	// 可串行化的事务持有共享锁，页面上的值一定已经提交了
+	return current, nil
 }
 
 func (t *Transaction) SetInt(blk *fm.BlockId, offset uint64, val int64, okToLog bool) error {
+	if err := t.checkActive(); err != nil {
+		return err
+	}
+	if err := t.checkRange(blk, offset, UINT64_LENGTH); err != nil {
+		return err
+	}
 	// 调用同步管理器加锁
+	if err := t.concurMgr.XLock(blk); err != nil {
+		return t.abortIfVictim(err)
+	}
 	buffer := t.myBuffers.GetBuffer(blk)
 	if buffer == nil {
 		return t.bufferNotExist(blk)
 	}
-	lsn := uint64(0)
-	var err error
 	if okToLog {
-		lsn, err = t.recoveryManager.SetInt(buffer, offset, val)
-		if err != nil {
-			return err
+		before := func(p *fm.Page) interface{} { return p.GetInt(offset) }
+		if err := t.saveVersion(buffer, blk, offset, before); err != nil {
+			return t.abortIfVictim(err)
 		}
 	}
+	// 写日志和修改数据期间持有页面的排他latch，其他协程看不到修改了一半的数据
+	buffer.LatchExclusive()
+	defer buffer.UnlatchExclusive()
 	p := buffer.Contents()
-	p.SetInt(offset, uint64(val))
+	if !okToLog {
+		p.SetInt(offset, uint64(val))
+		buffer.SetModified(t.txNum, 0)
+		return nil
+	}
+	// 恢复管理器先写日志再修改页面，日志写入失败时页面保持不变
+	lsn, err := t.recoveryManager.SetInt(buffer, offset, val)
+	if err != nil {
+		return err
+	}
+	p.SetInt(t.pageLSNOffset(), lsn)
 	buffer.SetModified(t.txNum, lsn)
 	return nil
 }
 
 func (t *Transaction) SetString(blk *fm.BlockId, offset uint64, val string, okToLog bool) error {
+	if err := t.checkActive(); err != nil {
+		return err
+	}
+	if err := t.checkRange(blk, offset, UINT64_LENGTH+uint64(len(val))); err != nil {
+		return err
+	}
 	// 调用同步管理器加锁
+	if err := t.concurMgr.XLock(blk); err != nil {
+		return t.abortIfVictim(err)
+	}
 	buffer := t.myBuffers.GetBuffer(blk)
 	if buffer == nil {
 		return t.bufferNotExist(blk)
 	}
-	lsn := uint64(0)
-	var err error
 	if okToLog {
-		lsn, err = t.recoveryManager.SetString(buffer, offset, val)
-		if err != nil {
-			return err
+		before := func(p *fm.Page) interface{} { return p.GetString(offset) }
+		if err := t.saveVersion(buffer, blk, offset, before); err != nil {
+			return t.abortIfVictim(err)
 		}
 	}
+	// 写日志和修改数据期间持有页面的排他latch，其他协程看不到修改了一半的数据
+	buffer.LatchExclusive()
+	defer buffer.UnlatchExclusive()
 	p := buffer.Contents()
-	p.SetString(offset, val)
+	if !okToLog {
+		p.SetString(offset, val)
+		buffer.SetModified(t.txNum, 0)
+		return nil
+	}
+	// 恢复管理器先写日志再修改页面，日志写入失败时页面保持不变
+	lsn, err := t.recoveryManager.SetString(buffer, offset, val)
+	if err != nil {
+		return err
+	}
+	p.SetInt(t.pageLSNOffset(), lsn)
 	buffer.SetModified(t.txNum, lsn)
 	return nil
 }
 
-func (t *Transaction) Size(fileName string) uint64 {
-	// 调用同步器加锁
-	size, _ := t.fileManager.Size(fileName)
-	return size
+/*
+SetBytes 把val原样写入页面，日志只记录新旧数据不同的部分。和SetInt一样，快照隔离按照偏移保存旧值，
+读写同一段数据时GetBytes要使用和写入时相同的长度
+*/
+func (t *Transaction) SetBytes(blk *fm.BlockId, offset uint64, val []byte, okToLog bool) error {
+	length := uint64(len(val))
+	return t.setBytes(blk, offset, val, okToLog, func(p *fm.Page) interface{} { return p.GetRawBytes(offset, length) })
 }
 
-func (t *Transaction) Append(fileName string) *fm.BlockId {
-	blk, err := t.fileManager.Append(fileName)
-	if err != nil {
+func (t *Transaction) SetInt32(blk *fm.BlockId, offset uint64, val int32, okToLog bool) error {
+	bytes := make([]byte, 4)
+	fm.NewPageByBytes(bytes).SetInt32(0, val)
+	return t.setBytes(blk, offset, bytes, okToLog, func(p *fm.Page) interface{} { return p.GetInt32(offset) })
+}
+
+func (t *Transaction) SetBool(blk *fm.BlockId, offset uint64, val bool, okToLog bool) error {
+	bytes := make([]byte, 1)
+	fm.NewPageByBytes(bytes).SetBool(0, val)
+	return t.setBytes(blk, offset, bytes, okToLog, func(p *fm.Page) interface{} { return p.GetBool(offset) })
+}
+
+func (t *Transaction) SetFloat64(blk *fm.BlockId, offset uint64, val float64, okToLog bool) error {
+	bytes := make([]byte, UINT64_LENGTH)
+	fm.NewPageByBytes(bytes).SetFloat64(0, val)
+	return t.setBytes(blk, offset, bytes, okToLog, func(p *fm.Page) interface{} { return p.GetFloat64(offset) })
+}
+
+// setBytes 所有按字节写入的数据共用的实现，before读出快照隔离需要保存的旧值
+func (t *Transaction) setBytes(blk *fm.BlockId, offset uint64, val []byte, okToLog bool, before func(p *fm.Page) interface{}) error {
+	if err := t.checkActive(); err != nil {
+		return err
+	}
+	if err := t.checkRange(blk, offset, uint64(len(val))); err != nil {
+		return err
+	}
+	// 调用同步管理器加锁
+	if err := t.concurMgr.XLock(blk); err != nil {
+		return t.abortIfVictim(err)
+	}
+	buffer := t.myBuffers.GetBuffer(blk)
+	if buffer == nil {
+		return t.bufferNotExist(blk)
+	}
+	if okToLog {
+		if err := t.saveVersion(buffer, blk, offset, before); err != nil {
+			return t.abortIfVictim(err)
+		}
+	}
+	// 写日志和修改数据期间持有页面的排他latch，其他协程看不到修改了一半的数据
+	buffer.LatchExclusive()
+	defer buffer.UnlatchExclusive()
+	p := buffer.Contents()
+	if !okToLog {
+		p.SetRawBytes(offset, val)
+		buffer.SetModified(t.txNum, 0)
 		return nil
 	}
-	return blk
+	// 日志分成多条写入时，写入失败之前的部分已经修改了页面，同样需要标记
+	lsn, err := t.recoveryManager.SetBytes(buffer, offset, val)
+	if lsn > 0 {
+		p.SetInt(t.pageLSNOffset(), lsn)
+		buffer.SetModified(t.txNum, lsn)
+	}
+	return err
+}
+
+// checkRange 写入的数据不能覆盖页面末尾的日志编号
+func (t *Transaction) checkRange(blk *fm.BlockId, offset uint64, length uint64) error {
+	if offset+length > t.BlockSize() {
+		return fmt.Errorf("writing %d bytes at offset %d of blk %d of file %s overflows block size %d",
+			length, offset, blk.Number(), blk.FileName(), t.BlockSize())
+	}
+	return nil
+}
+
+func (t *Transaction) pageLSNOffset() uint64 {
+	return t.fileManager.BlockSize() - PAGE_LSN_SIZE
+}
+
+/*
+modifyPage 回滚和恢复时修改页面。在持有页面排他latch的情况下调用modify，modify可以先写日志再修改页面，
+参数是页面内容和页面末尾记录的日志编号，返回修改对应的日志编号，0表示没有修改。
+页面末尾的日志编号更新为返回的编号。回滚的事务已经持有区块的排他锁，这里不再加锁
+*/
+func (t *Transaction) modifyPage(blk *fm.BlockId, modify func(p *fm.Page, pageLSN uint64) (uint64, error)) error {
+	if err := t.myBuffers.Pin(blk); err != nil {
+		return err
+	}
+	defer t.Unpin(blk)
+	buffer := t.myBuffers.GetBuffer(blk)
+	buffer.LatchExclusive()
+	defer buffer.UnlatchExclusive()
+
+	p := buffer.Contents()
+	lsn, err := modify(p, p.GetInt(t.pageLSNOffset()))
+	if err != nil || lsn == 0 {
+		return err
+	}
+	p.SetInt(t.pageLSNOffset(), lsn)
+	buffer.SetModified(t.txNum, lsn)
+	return nil
+}
+
+/*
+saveVersion 修改数据之前把旧值保存到版本存储中，回滚时的写入不记录版本，回滚结束后事务的版本会被整体删除。
+事务持有区块的排他锁，其他事务不会同时修改这个数据，在修改页面之前保存旧值读者看到的结果不变
+*/
+func (t *Transaction) saveVersion(buffer *bm.Buffer, blk *fm.BlockId, offset uint64, before func(p *fm.Page) interface{}) error {
+	buffer.LatchShared()
+	defer buffer.UnlatchShared()
+	return t.versions.Write(t.txNum, t.snapshot, blk, offset, before(buffer.Contents()))
+}
+
+func (t *Transaction) Size(fileName string) (uint64, error) {
+	if err := t.checkActive(); err != nil {
+		return 0, err
+	}
+	// 可串行化的事务锁住文件末尾，在事务结束之前其他事务不能增加区块，避免幻读
+	if t.isolation == SERIALIZABLE {
+		if err := t.concurMgr.SLockEndOfFile(fileName); err != nil {
+			return 0, t.abortIfVictim(err)
+		}
+	}
+	return t.fileManager.Size(fileName)
+}
+
+func (t *Transaction) Append(fileName string) (*fm.BlockId, error) {
+	if err := t.checkActive(); err != nil {
+		return nil, err
+	}
+	if err := t.concurMgr.XLockEndOfFile(fileName); err != nil {
+		return nil, t.abortIfVictim(err)
+	}
+	return t.fileManager.Append(fileName)
 }
 
+// BlockSize 返回页面中可以存放数据的大小，不包括页面末尾的日志编号
 func (t *Transaction) BlockSize() uint64 {
-	return t.fileManager.BlockSize()
+	return t.fileManager.BlockSize() - PAGE_LSN_SIZE
 }
 
 func (t *Transaction) AvailableBuffers() uint64 {
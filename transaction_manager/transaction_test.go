@@ -0,0 +1,455 @@
+package transaction_manager
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	bm "simpleDb/buffer_manager"
+	fm "simpleDb/file_manager"
+	lm "simpleDb/log_manager"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestDb(t *testing.T, numBuffers uint32) (*fm.FileManager, *lm.LogManager, *bm.BufferManager) {
+	fileManager, err := fm.NewFileManager(filepath.Join(t.TempDir(), "txtest"), 400)
+	require.Nil(t, err)
+	logManager, err := lm.NewLogManager(fileManager, "logfile")
+	require.Nil(t, err)
+	return fileManager, logManager, bm.NewBufferManager(fileManager, logManager, numBuffers)
+}
+
+func TestTransaction_ConcurrentPageAccess(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 8)
+	blk := fm.NewBlockId("test_file", 1)
+	values := []string{strings.Repeat("a", 100), strings.Repeat("b", 60)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(val string) {
+			defer wg.Done()
+			tx := NewTransaction(fileManager, logManager, bufferManager)
+			require.Nil(t, tx.Pin(blk))
+			for j := 0; j < 50; j++ {
+				require.Nil(t, tx.SetString(blk, 40, val, false))
+				s, err := tx.GetString(blk, 40)
+				require.Nil(t, err)
+				// 读到的一定是某一次完整写入的字符串，不会是两次写入交错的结果
+				require.True(t, s == values[0] || s == values[1], s)
+			}
+			tx.Commit()
+		}(values[i%2])
+	}
+	wg.Wait()
+}
+
+func TestTransaction_ReserveReleasedOnCommit(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 4)
+	tx := NewTransaction(fileManager, logManager, bufferManager)
+	reservation, err := tx.Reserve(3)
+	require.Nil(t, err)
+	_, err = reservation.PinNew("temp_join")
+	require.Nil(t, err)
+	require.Equal(t, uint64(1), tx.AvailableBuffers())
+
+	require.Nil(t, tx.Commit())
+	require.Equal(t, uint32(4), bufferManager.Available())
+}
+
+func TestTransaction_ReserveReleasedOnRollback(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 4)
+	tx := NewTransaction(fileManager, logManager, bufferManager)
+	reservation, err := tx.Reserve(3)
+	require.Nil(t, err)
+	_, err = reservation.PinNew("temp_join")
+	require.Nil(t, err)
+	require.Equal(t, uint64(1), tx.AvailableBuffers())
+
+	require.Nil(t, tx.Rollback())
+	require.Equal(t, uint32(4), bufferManager.Available())
+}
+
+func TestTransaction_ReportPinLeaks(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 4)
+	var leaks []error
+	tx := NewTransaction(fileManager, logManager, bufferManager, WithPinLeakHandler(func(err error) {
+		leaks = append(leaks, err)
+	}))
+	blk1 := fm.NewBlockId("test_file", 1)
+	blk2 := fm.NewBlockId("test_file", 2)
+	require.Nil(t, tx.Pin(blk1))
+	require.Nil(t, tx.Pin(blk2))
+	tx.Unpin(blk1)
+
+	// 忘记unpin的blk2在提交时被报告，随后仍然被释放
+	require.Nil(t, tx.Commit())
+	require.Equal(t, 1, len(leaks))
+	require.True(t, errors.Is(leaks[0], bm.ErrPinLeak))
+	require.Contains(t, leaks[0].Error(), "test_file:2(x1)")
+	require.Equal(t, uint32(4), bufferManager.Available())
+}
+
+func TestTransaction_TxNumSurvivesRestart(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "txnum")
+	fileManager, logManager, bufferManager := openTestDb(t, dir)
+	last := uint64(0)
+	for i := 0; i < TX_NUM_SAVE_INTERVAL+10; i++ {
+		tx := NewTransaction(fileManager, logManager, bufferManager)
+		require.Greater(t, tx.txNum, last)
+		last = tx.txNum
+		tx.Commit()
+	}
+	// 最后一个事务没有结束就崩溃了，它的事务号在日志中也不能再被使用
+	tx := NewTransaction(fileManager, logManager, bufferManager)
+	last = tx.txNum
+	require.Nil(t, logManager.Flush())
+
+	// 重启之后新的事务号比崩溃之前的都大
+	fileManager, logManager, bufferManager = openTestDb(t, dir)
+	tx = NewTransaction(fileManager, logManager, bufferManager)
+	require.Greater(t, tx.txNum, last)
+	tx.Recover()
+	last = tx.txNum
+
+	// 高水位文件丢失时从日志中找回
+	require.Nil(t, os.Remove(filepath.Join(dir, "logfile.txnum")))
+	fileManager, logManager, bufferManager = openTestDb(t, dir)
+	tx = NewTransaction(fileManager, logManager, bufferManager)
+	require.Greater(t, tx.txNum, last)
+}
+
+func TestTransaction_TxNumPerDatabase(t *testing.T) {
+	fileManager1, logManager1, bufferManager1 := newTestDb(t, 8)
+	fileManager2, logManager2, bufferManager2 := newTestDb(t, 8)
+	for i := uint64(1); i <= 3; i++ {
+		require.Equal(t, i, NewTransaction(fileManager1, logManager1, bufferManager1).txNum)
+	}
+	// 不同数据库的事务号各自从1开始分配
+	require.Equal(t, uint64(1), NewTransaction(fileManager2, logManager2, bufferManager2).txNum)
+	require.Equal(t, uint64(4), NewTransaction(fileManager1, logManager1, bufferManager1).txNum)
+}
+
+func TestTransaction_SameLogFileSharesDatabase(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "shared")
+	fileManager, logManager1, bufferManager := openTestDb(t, dir)
+	logManager2, err := lm.NewLogManager(fileManager, "logfile")
+	require.Nil(t, err)
+
+	// 打开同一个日志文件的日志管理器共享锁表、提交策略和事务号
+	lockTable := LockTableFor(logManager1)
+	require.Same(t, lockTable, LockTableFor(logManager2))
+	SetForcePolicy(logManager2, FORCE)
+	require.Equal(t, FORCE, databaseOf(logManager1).policy())
+
+	tx := NewTransaction(fileManager, logManager1, bufferManager)
+	require.NotNil(t, CloseDatabase(logManager2))
+	require.Nil(t, tx.Commit())
+	require.Nil(t, CloseDatabase(logManager2))
+
+	// 关闭之后重新创建，事务号从磁盘上恢复，不会重复
+	require.NotSame(t, lockTable, LockTableFor(logManager1))
+	require.Equal(t, NO_FORCE, databaseOf(logManager1).policy())
+	require.Greater(t, NewTransaction(fileManager, logManager1, bufferManager).txNum, tx.txNum)
+}
+
+func TestTransaction_RejectUseAfterCompletion(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 8)
+	blk := fm.NewBlockId("test_file", 1)
+
+	tx := NewTransaction(fileManager, logManager, bufferManager)
+	require.Equal(t, ACTIVE, tx.State())
+	require.Nil(t, tx.Pin(blk))
+	require.Nil(t, tx.SetInt(blk, 80, 1, true))
+	tx.Unpin(blk)
+	require.Nil(t, tx.Commit())
+	require.Equal(t, COMMITTED, tx.State())
+
+	require.True(t, errors.Is(tx.Pin(blk), ErrTxNotActive))
+	require.True(t, errors.Is(tx.SetInt(blk, 80, 2, true), ErrTxNotActive))
+	_, err := tx.GetInt(blk, 80)
+	require.True(t, errors.Is(err, ErrTxNotActive))
+	_, err = tx.Append("test_file")
+	require.True(t, errors.Is(err, ErrTxNotActive))
+	require.True(t, errors.Is(tx.Savepoint("a"), ErrTxNotActive))
+	require.True(t, errors.Is(tx.Commit(), ErrTxNotActive))
+	require.True(t, errors.Is(tx.Rollback(), ErrTxNotActive))
+
+	tx = NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx.Rollback())
+	require.Equal(t, ABORTED, tx.State())
+	require.True(t, errors.Is(tx.Commit(), ErrTxNotActive))
+}
+
+func TestTransaction_CommitNotDurable(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 8)
+	blk := fm.NewBlockId("test_file", 1)
+	tx := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx.Pin(blk))
+	require.Nil(t, tx.SetInt(blk, 80, 1, true))
+	tx.Unpin(blk)
+
+	// 日志写不进磁盘，提交日志只在日志缓冲区中
+	fileManager.SetWriteHook(func(blk *fm.BlockId) error {
+		if blk.FileName() == "logfile" {
+			return errors.New("disk full")
+		}
+		return nil
+	})
+	err := tx.Commit()
+	require.True(t, errors.Is(err, ErrNotDurable), err)
+	require.Equal(t, COMMITTED, tx.State())
+
+	// 锁已经释放，其他事务可以继续修改
+	fileManager.SetWriteHook(nil)
+	tx = NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx.Pin(blk))
+	require.Nil(t, tx.SetInt(blk, 80, 2, true))
+	tx.Unpin(blk)
+	require.Nil(t, tx.Commit())
+}
+
+func TestTransaction_SetBytesAndTypedValues(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 8)
+	blk := fm.NewBlockId("test_file", 1)
+
+	tx1 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx1.Pin(blk))
+	require.Nil(t, tx1.SetBytes(blk, 20, []byte("hello world"), true))
+	require.Nil(t, tx1.SetInt32(blk, 60, -5, true))
+	require.Nil(t, tx1.SetBool(blk, 64, true, true))
+	require.Nil(t, tx1.SetFloat64(blk, 72, 2.5, true))
+	require.Nil(t, tx1.Commit())
+
+	tx2 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx2.Pin(blk))
+	// 只有改变的部分写入日志
+	before := logManager.LatestLSN()
+	require.Nil(t, tx2.SetBytes(blk, 20, []byte("hello there"), true))
+	iterator := logManager.Iterator()
+	logRecord, err := DecodeLogRecord(nil, iterator.Next())
+	require.Nil(t, err)
+	require.Greater(t, iterator.LSN(), before)
+	rec := logRecord.(*SetBytesRecord)
+	require.Equal(t, uint64(26), rec.Offset())
+	require.Equal(t, []byte("world"), rec.OldValue())
+	require.Equal(t, []byte("there"), rec.NewValue())
+	// 没有变化时不写日志
+	before = logManager.LatestLSN()
+	require.Nil(t, tx2.SetBytes(blk, 20, []byte("hello there"), true))
+	require.Equal(t, before, logManager.LatestLSN())
+
+	require.Nil(t, tx2.SetInt32(blk, 60, 1000, true))
+	require.Nil(t, tx2.SetBool(blk, 64, false, true))
+	require.Nil(t, tx2.SetFloat64(blk, 72, -1.25, true))
+	i32, err := tx2.GetInt32(blk, 60)
+	require.Nil(t, err)
+	require.Equal(t, int32(1000), i32)
+	require.Nil(t, tx2.Rollback())
+
+	tx3 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx3.Pin(blk))
+	bytes, err := tx3.GetBytes(blk, 20, 11)
+	require.Nil(t, err)
+	require.Equal(t, "hello world", string(bytes))
+	i32, err = tx3.GetInt32(blk, 60)
+	require.Nil(t, err)
+	require.Equal(t, int32(-5), i32)
+	b, err := tx3.GetBool(blk, 64)
+	require.Nil(t, err)
+	require.True(t, b)
+	f, err := tx3.GetFloat64(blk, 72)
+	require.Nil(t, err)
+	require.Equal(t, 2.5, f)
+}
+
+func TestTransaction_SetBytesSplitsLargeChanges(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 8)
+	blk := fm.NewBlockId("test_file", 1)
+	val := []byte(strings.Repeat("x", 300))
+
+	tx := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx.Pin(blk))
+	before := logManager.LatestLSN()
+	require.Nil(t, tx.SetBytes(blk, 40, val, true))
+	// 一个区块放不下的修改分成多条日志
+	records := 0
+	iterator := logManager.Iterator()
+	for iterator.HasNext() {
+		bytes := iterator.Next()
+		if iterator.LSN() <= before {
+			break
+		}
+		logRecord, err := DecodeLogRecord(nil, bytes)
+		require.Nil(t, err)
+		require.Equal(t, SETBYTES, logRecord.Op())
+		records++
+	}
+	require.Greater(t, records, 1)
+	got, err := tx.GetBytes(blk, 40, 300)
+	require.Nil(t, err)
+	require.Equal(t, val, got)
+
+	require.Nil(t, tx.Rollback())
+	tx = NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx.Pin(blk))
+	got, err = tx.GetBytes(blk, 40, 300)
+	require.Nil(t, err)
+	require.Equal(t, make([]byte, 300), got)
+}
+
+func TestTransaction_SetStringTooLarge(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 8)
+	blk := fm.NewBlockId("test_file", 1)
+
+	tx := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx.Pin(blk))
+	// 页面放得下，但是日志放不下，修改之前就返回错误
+	err := tx.SetString(blk, 0, strings.Repeat("a", 320), true)
+	require.True(t, errors.Is(err, ErrLogTooLarge))
+	val, err := tx.GetString(blk, 0)
+	require.Nil(t, err)
+	require.Equal(t, "", val)
+
+	// 新旧两个值都要写入日志，覆盖一个长字符串时可写的长度更短
+	require.Nil(t, tx.SetString(blk, 0, strings.Repeat("b", 150), true))
+	err = tx.SetString(blk, 0, strings.Repeat("c", 200), true)
+	require.True(t, errors.Is(err, ErrLogTooLarge))
+	val, err = tx.GetString(blk, 0)
+	require.Nil(t, err)
+	require.Equal(t, strings.Repeat("b", 150), val)
+	require.Nil(t, tx.Commit())
+}
+
+func TestTransaction_SetIntClrSize(t *testing.T) {
+	// 区块200字节时一条日志最多184字节，SETINT日志是64字节加上文件名，补偿日志再多8个字节
+	fileManager, err := fm.NewFileManager(filepath.Join(t.TempDir(), "txtest"), 200)
+	require.Nil(t, err)
+	logManager, err := lm.NewLogManager(fileManager, "logfile")
+	require.Nil(t, err)
+	bufferManager := bm.NewBufferManager(fileManager, logManager, 8)
+
+	// 日志本身刚好放得下，补偿日志放不下，这样的修改无法回滚，写入之前就返回错误
+	tooLong := fm.NewBlockId(strings.Repeat("f", 120), 0)
+	tx := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx.Pin(tooLong))
+	require.Equal(t, logManager.MaxRecordSize(), setIntLogSize(tooLong))
+	err = tx.SetInt(tooLong, 0, 7, true)
+	require.True(t, errors.Is(err, ErrLogTooLarge), "%v", err)
+
+	// 补偿日志刚好放得下，修改可以写入并且回滚
+	longest := fm.NewBlockId(strings.Repeat("f", 112), 0)
+	require.Equal(t, logManager.MaxRecordSize(), clrLogSize(longest, UINT64_LENGTH))
+	require.Nil(t, tx.Pin(longest))
+	require.Nil(t, tx.SetInt(longest, 0, 7, true))
+	require.Nil(t, tx.Rollback())
+	require.Equal(t, ABORTED, tx.State())
+
+	tx = NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx.Pin(longest))
+	val, err := tx.GetInt(longest, 0)
+	require.Nil(t, err)
+	require.Equal(t, uint64(0), val)
+	require.Nil(t, tx.Commit())
+}
+
+func TestTransaction_SetIntLogFailureKeepsPage(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 8)
+	blk := fm.NewBlockId("test_file", 1)
+
+	tx := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx.Pin(blk))
+	fileManager.SetWriteHook(func(blk *fm.BlockId) error {
+		if blk.FileName() == "logfile" {
+			return errors.New("disk full")
+		}
+		return nil
+	})
+	// 日志缓冲区写满之后日志写不进去，这次修改不能出现在页面上
+	var err error
+	last := 0
+	for i := 1; i <= 100 && err == nil; i++ {
+		err = tx.SetInt(blk, 80, int64(i), true)
+		last = i
+	}
+	require.NotNil(t, err)
+	val, err := tx.GetInt(blk, 80)
+	require.Nil(t, err)
+	require.Equal(t, uint64(last-1), val)
+	fileManager.SetWriteHook(nil)
+}
+
+func TestTransaction_RollbackFailureKeepsLocks(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "rollback")
+	fileManager, logManager, bufferManager := openTestDb(t, dir)
+	blk := fm.NewBlockId("test_file", 1)
+
+	tx1 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx1.Pin(blk))
+	require.Nil(t, tx1.SetInt(blk, 80, 7, true))
+	require.Nil(t, tx1.Commit())
+
+	tx2 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx2.Pin(blk))
+	for i := 1; i <= 20; i++ {
+		require.Nil(t, tx2.SetInt(blk, 80, int64(100+i), true))
+	}
+	// 补偿日志写不进磁盘，回滚到一半失败
+	fileManager.SetWriteHook(func(blk *fm.BlockId) error {
+		if blk.FileName() == "logfile" {
+			return errors.New("disk full")
+		}
+		return nil
+	})
+	err := tx2.Rollback()
+	require.NotNil(t, err)
+	require.False(t, errors.Is(err, ErrNotDurable))
+	require.Equal(t, FAILED, tx2.State())
+	require.True(t, errors.Is(tx2.Rollback(), ErrTxNotActive))
+
+	// 失败的事务继续持有排他锁和版本，其他事务读到的是修改之前的值，也不能写入
+	LockTableFor(logManager).SetTimeout(30 * time.Millisecond)
+	tx3 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx3.Pin(blk))
+	val, err := tx3.GetInt(blk, 80)
+	require.Nil(t, err)
+	require.Equal(t, uint64(7), val)
+	err = tx3.SetInt(blk, 80, 8, true)
+	require.True(t, errors.Is(err, ErrLockAbort), err)
+
+	// 重启之后恢复撤销剩下的修改
+	fileManager.SetWriteHook(nil)
+	fileManager, logManager, bufferManager = openTestDb(t, dir)
+	require.Nil(t, NewTransaction(fileManager, logManager, bufferManager).Recover())
+	require.Equal(t, uint64(7), readDisk(t, fileManager, blk).GetInt(80))
+}
+
+func TestTransaction_StartRecordFailure(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 8)
+	blk := fm.NewBlockId("test_file", 1)
+
+	// 日志缓冲区写满之后开始日志写不进去，事务一创建就是FAILED
+	fileManager.SetWriteHook(func(blk *fm.BlockId) error {
+		if blk.FileName() == "logfile" {
+			return errors.New("disk full")
+		}
+		return nil
+	})
+	var tx *Transaction
+	for i := 0; i < 1000; i++ {
+		tx = NewTransaction(fileManager, logManager, bufferManager)
+		if tx.State() == FAILED {
+			break
+		}
+	}
+	require.Equal(t, FAILED, tx.State())
+	err := tx.Pin(blk)
+	require.True(t, errors.Is(err, ErrTxNotActive), err)
+	require.Contains(t, err.Error(), "disk full")
+	require.True(t, errors.Is(tx.Commit(), ErrTxNotActive))
+	require.True(t, errors.Is(tx.Rollback(), ErrTxNotActive))
+	fileManager.SetWriteHook(nil)
+}
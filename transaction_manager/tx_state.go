@@ -0,0 +1,73 @@
+package transaction_manager
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TxState 事务的状态，事务创建时是ACTIVE，提交或者回滚之后不能再使用
+type TxState int
+
+const (
+	ACTIVE     TxState = iota // 可以读写数据
+	COMMITTING                // 正在写入提交日志
+	COMMITTED                 // 已经提交，提交日志可能还没有写入磁盘，见ErrNotDurable
+	ABORTED                   // 已经回滚
+	FAILED                    // 回滚没有完成，事务继续持有锁，只有重启之后的恢复才能撤销剩下的修改；开始日志没能写入的事务也是这个状态
+)
+
+var txStateNames = map[TxState]string{
+	ACTIVE:     "ACTIVE",
+	COMMITTING: "COMMITTING",
+	COMMITTED:  "COMMITTED",
+	ABORTED:    "ABORTED",
+	FAILED:     "FAILED",
+}
+
+func (s TxState) String() string {
+	if name, ok := txStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("TxState(%d)", int(s))
+}
+
+// ErrTxNotActive 事务已经提交或者回滚之后还在使用
+var ErrTxNotActive = errors.New("transaction is not active")
+
+/*
+ErrNotDurable 提交日志已经写入日志缓冲区但是没能写入磁盘。事务在内存中已经提交，锁也已经释放，
+之后任何一次日志写盘都会把它一起写入；如果在那之前崩溃，恢复时这个事务会被回滚。
+回滚时修改已经全部撤销，但是回滚日志或者页面没能写入磁盘，同样返回这个错误，事务算作已经回滚
+*/
+var ErrNotDurable = errors.New("commit record is not durable")
+
+// State 返回事务当前的状态
+func (t *Transaction) State() TxState {
+	return t.state
+}
+
+/*
+checkActive 事务提交或者回滚之后所有操作都返回ErrTxNotActive。事务被锁表选为牺牲者之后(例如WOUND_WAIT下
+被年老的事务伤害)，即使不再请求锁，下一次操作也会通过abortIfVictim回滚事务，释放的锁让等待的事务继续执行，
+操作返回满足errors.Is(err, ErrDeadlock)的错误
+*/
+func (t *Transaction) checkActive() error {
+	if err := t.checkState(); err != nil {
+		return err
+	}
+	if !t.undoing && t.concurMgr.Wounded() {
+		return t.abortIfVictim(fmt.Errorf("%w: tx %d was chosen as a victim", ErrDeadlock, t.txNum))
+	}
+	return nil
+}
+
+// checkState 只检查事务的状态，回滚不受牺牲者标记的影响
+func (t *Transaction) checkState() error {
+	if t.startErr != nil {
+		return fmt.Errorf("%w: tx %d is %v: %v", ErrTxNotActive, t.txNum, t.state, t.startErr)
+	}
+	if t.state != ACTIVE {
+		return fmt.Errorf("%w: tx %d is %v", ErrTxNotActive, t.txNum, t.state)
+	}
+	return nil
+}
@@ -2,33 +2,40 @@ package transaction_manager
 
 import fm "simpleDb/file_manager"
 
+// TxSub 事务替身，所有读写都直接作用在给定的page上。测试中使用，恢复时也用它在持有latch的情况下把日志作用到页面上
 type TxSub struct {
 	p *fm.Page
 }
 
-func (t *TxSub) Unpin(blk *fm.BlockId) {
-	//TODO implement me
-	panic("implement me")
+func (t *TxSub) Unpin(_ *fm.BlockId) {
+
+}
+
+func (t *TxSub) GetInt(_ *fm.BlockId, offset uint64) (uint64, error) {
+	return t.p.GetInt(offset), nil
 }
 
-func (t *TxSub) GetInt(blk *fm.BlockId, offset uint64) (uint64, error) {
-	//TODO implement me
-	panic("implement me")
+func (t *TxSub) GetString(_ *fm.BlockId, offset uint64) (string, error) {
+	return t.p.GetString(offset), nil
 }
 
-func (t *TxSub) GetString(blk *fm.BlockId, offset uint64) (string, error) {
-	//TODO implement me
-	panic("implement me")
+func (t *TxSub) SetInt(_ *fm.BlockId, offset uint64, val int64, _ bool) error {
+	t.p.SetInt(offset, uint64(val))
+	return nil
 }
 
-func (t *TxSub) SetInt(blk *fm.BlockId, offset uint64, val int64, okToLog bool) error {
-	//TODO implement me
-	panic("implement me")
+func (t *TxSub) SetString(_ *fm.BlockId, offset uint64, val string, _ bool) error {
+	t.p.SetString(offset, val)
+	return nil
 }
 
-func (t *TxSub) SetString(blk *fm.BlockId, offset uint64, val string, okToLog bool) error {
-	//TODO implement me
-	panic("implement me")
+func (t *TxSub) GetBytes(_ *fm.BlockId, offset uint64, length uint64) ([]byte, error) {
+	return t.p.GetRawBytes(offset, length), nil
+}
+
+func (t *TxSub) SetBytes(_ *fm.BlockId, offset uint64, val []byte, _ bool) error {
+	t.p.SetRawBytes(offset, val)
+	return nil
 }
 
 func NewTxSub(p *fm.Page) *TxSub {
@@ -37,36 +44,32 @@ func NewTxSub(p *fm.Page) *TxSub {
 	}
 }
 
-func (t *TxSub) Commit() {
-
-}
-
-func (t *TxSub) Rollback() {
-
+func (t *TxSub) Commit() error {
+	return nil
 }
 
-func (t *TxSub) Recover() {
-
+func (t *TxSub) Rollback() error {
+	return nil
 }
 
-func (t *TxSub) Pin(_ *fm.BlockId) {
-
+func (t *TxSub) Recover() error {
+	return nil
 }
 
-func (t *TxSub) UnPin(_ *fm.BlockId) {
-
+func (t *TxSub) Pin(_ *fm.BlockId) error {
+	return nil
 }
 
 func (t *TxSub) AvailableBuffers() uint64 {
 	return 0
 }
 
-func (t *TxSub) Size(_ string) uint64 {
-	return 0
+func (t *TxSub) Size(_ string) (uint64, error) {
+	return 0, nil
 }
 
-func (t *TxSub) Append(_ string) *fm.BlockId {
-	return nil
+func (t *TxSub) Append(_ string) (*fm.BlockId, error) {
+	return nil, nil
 }
 
 func (t *TxSub) BlockSize() uint64 {
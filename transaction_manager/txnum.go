@@ -0,0 +1,89 @@
+package transaction_manager
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	fm "simpleDb/file_manager"
+	lm "simpleDb/log_manager"
+	"sync"
+)
+
+// TX_NUM_SAVE_INTERVAL 每分配这么多个事务号保存一次高水位，重启时只需要扫描上次保存之后的日志
+const TX_NUM_SAVE_INTERVAL = 64
+
+/*
+txNumAllocator 分配同一个数据库中的事务号，事务号从1开始递增，重启之后也不会重复使用。
+高水位文件记录下一个可用的事务号以及保存时最新的日志编号，每个事务开始时都会写START，
+所以之后分配的事务号一定出现在这个编号之后的日志中，重启时用高水位加上这段日志就能找回下一个事务号。
+高水位只是减少需要扫描的日志，保存失败不影响正确性
+*/
+type txNumAllocator struct {
+	mu        sync.Mutex
+	loaded    bool
+	nextTxNum uint64
+	savedNum  uint64 // 最近一次保存的高水位
+}
+
+// txNumFile 高水位文件的名称，和日志文件放在同一个目录中
+func txNumFile(logManager *lm.LogManager) string {
+	return logManager.LogFile() + ".txnum"
+}
+
+func (a *txNumAllocator) next(fileManager *fm.FileManager, logManager *lm.LogManager) uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.loaded {
+		a.nextTxNum = recoverTxNum(fileManager, logManager)
+		a.loaded = true
+		a.save(fileManager, logManager)
+	}
+	txNum := a.nextTxNum
+	a.nextTxNum += 1
+	if a.nextTxNum-a.savedNum >= TX_NUM_SAVE_INTERVAL {
+		a.save(fileManager, logManager)
+	}
+	return txNum
+}
+
+func (a *txNumAllocator) save(fileManager *fm.FileManager, logManager *lm.LogManager) {
+	if fileManager.IsReadOnly() {
+		return
+	}
+	data := make([]byte, 3*UINT64_LENGTH)
+	binary.LittleEndian.PutUint64(data, a.nextTxNum)
+	binary.LittleEndian.PutUint64(data[UINT64_LENGTH:], logManager.LatestLSN())
+	binary.LittleEndian.PutUint64(data[2*UINT64_LENGTH:], uint64(crc32.ChecksumIEEE(data[:2*UINT64_LENGTH])))
+	if fileManager.WriteFileAtomic(txNumFile(logManager), data) == nil {
+		a.savedNum = a.nextTxNum
+	}
+}
+
+// recoverTxNum 根据高水位文件和之后的日志找出下一个可用的事务号，没有高水位文件时扫描全部日志
+func recoverTxNum(fileManager *fm.FileManager, logManager *lm.LogManager) uint64 {
+	next, fromLSN := uint64(1), uint64(0)
+	data, err := fileManager.ReadFile(txNumFile(logManager))
+	if err == nil && len(data) == 3*UINT64_LENGTH &&
+		binary.LittleEndian.Uint64(data[2*UINT64_LENGTH:]) == uint64(crc32.ChecksumIEEE(data[:2*UINT64_LENGTH])) {
+		next = binary.LittleEndian.Uint64(data)
+		fromLSN = binary.LittleEndian.Uint64(data[UINT64_LENGTH:])
+		if fromLSN > logManager.LatestLSN() {
+			fromLSN = 0
+		}
+	}
+
+	iter := logManager.ForwardIteratorFromLSN(fromLSN)
+	if iter == nil {
+		return next
+	}
+	for iter.HasNext() {
+		logRecord, err := DecodeLogRecord(nil, iter.Next())
+		if err != nil || logRecord.Op() == CHECKPOINT || logRecord.Op() == NQCKPT {
+			continue
+		}
+		if txNum := logRecord.TxNumber(); txNum >= next {
+			next = txNum + 1
+		}
+	}
+	return next
+}
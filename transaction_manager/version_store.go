@@ -0,0 +1,252 @@
+package transaction_manager
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	fm "simpleDb/file_manager"
+	"sync"
+)
+
+// NO_SNAPSHOT 不使用快照的事务在修改数据时传入，不检查写冲突
+const NO_SNAPSHOT = math.MaxUint64
+
+// ErrSerialization 要修改的数据在事务开始之后被其他事务修改并提交了，事务应该回滚后重试
+var ErrSerialization = errors.New("could not serialize access due to concurrent update")
+
+// SerializationError 记录了发生写冲突的位置，可以用errors.Is(err, ErrSerialization)判断
+type SerializationError struct {
+	TxNum  uint64
+	Blk    *fm.BlockId
+	Offset uint64
+}
+
+func (e *SerializationError) Error() string {
+	return fmt.Sprintf("%v: tx %d writing offset %d of blk %d of file %s", ErrSerialization, e.TxNum, e.Offset, e.Blk.Number(), e.Blk.FileName())
+}
+
+func (e *SerializationError) Is(target error) bool {
+	return target == ErrSerialization
+}
+
+// cellKey 区块中一个数据的位置
+type cellKey struct {
+	fileName string
+	blkNum   uint64
+	offset   uint64
+}
+
+func cellOf(blk *fm.BlockId, offset uint64) cellKey {
+	return cellKey{fileName: blk.FileName(), blkNum: blk.Number(), offset: offset}
+}
+
+// version 一个事务修改某个数据之前的值，同一个事务多次修改同一个数据只记录第一次修改前的值
+type version struct {
+	writer   uint64
+	commitTs uint64      // 提交时间戳，0表示还没有提交
+	before   interface{} // uint64或者string
+}
+
+/*
+VersionStore 多版本并发控制使用的版本存储，同一个数据库中的所有事务共用一个。
+页面上保存的总是最新的(可能没有提交的)值，chains按照从新到旧的顺序记录每次修改之前的值。
+读数据时从页面上的值开始，沿着版本链依次撤销对当前快照不可见的修改，就得到快照看到的值。
+修改同一个数据的事务被排他锁串行化，所以版本链的顺序就是修改的顺序。
+*/
+type VersionStore struct {
+	mu      sync.Mutex
+	clock   uint64            // 最近一次提交的时间戳
+	active  map[uint64]uint64 // 活跃事务的快照时间戳
+	chains  map[cellKey][]*version
+	written map[uint64][]cellKey // 每个事务修改过的数据，提交或者回滚时使用
+}
+
+func NewVersionStore() *VersionStore {
+	return &VersionStore{
+		active:  make(map[uint64]uint64),
+		chains:  make(map[cellKey][]*version),
+		written: make(map[uint64][]cellKey),
+	}
+}
+
+// Begin 为事务分配快照时间戳，快照能看到时间戳不超过它的所有已提交修改
+func (v *VersionStore) Begin(txNum uint64) uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.active[txNum] = v.clock
+	return v.clock
+}
+
+// Snapshot 返回当前的时间戳，用这个时间戳读数据能看到目前所有已提交的修改
+func (v *VersionStore) Snapshot() uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.clock
+}
+
+// visible 修改对给定的事务和快照是否可见
+func (ver *version) visible(txNum uint64, snapshot uint64) bool {
+	return ver.writer == txNum || (ver.commitTs != 0 && ver.commitTs <= snapshot)
+}
+
+/*
+Read 根据页面上的当前值计算事务在快照中看到的值，调用者需要持有页面的共享latch，
+这样页面上的值和版本链是一致的。
+*/
+func (v *VersionStore) Read(txNum uint64, snapshot uint64, blk *fm.BlockId, offset uint64, current interface{}) interface{} {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for _, ver := range v.chains[cellOf(blk, offset)] {
+		if ver.visible(txNum, snapshot) {
+			break
+		}
+		current = ver.before
+	}
+	return current
+}
+
+// ReadCommitted 与Read相同，但是使用当前的时间戳，能看到目前所有已提交的修改
+func (v *VersionStore) ReadCommitted(txNum uint64, blk *fm.BlockId, offset uint64, current interface{}) interface{} {
+	v.mu.Lock()
+	snapshot := v.clock
+	v.mu.Unlock()
+	return v.Read(txNum, snapshot, blk, offset, current)
+}
+
+/*
+Write 在事务修改数据之前调用，保存修改之前的值。如果数据在事务的快照之后被其他事务修改并提交了，
+返回SerializationError(先提交者获胜)，snapshot为NO_SNAPSHOT时不检查。调用者需要持有区块的排他锁。
+*/
+func (v *VersionStore) Write(txNum uint64, snapshot uint64, blk *fm.BlockId, offset uint64, before interface{}) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	cell := cellOf(blk, offset)
+	chain := v.chains[cell]
+	for _, ver := range chain {
+		if ver.commitTs != 0 {
+			if ver.commitTs > snapshot {
+				return &SerializationError{TxNum: txNum, Blk: blk, Offset: offset}
+			}
+			break
+		}
+	}
+	if len(chain) > 0 && chain[0].writer == txNum {
+		return nil
+	}
+	ver := &version{writer: txNum, before: before}
+	v.chains[cell] = append([]*version{ver}, chain...)
+	v.written[txNum] = append(v.written[txNum], cell)
+	return nil
+}
+
+// Commit 为事务分配提交时间戳，之后开始的快照都能看到事务的修改
+func (v *VersionStore) Commit(txNum uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.clock++
+	for _, cell := range v.written[txNum] {
+		for _, ver := range v.chains[cell] {
+			if ver.writer == txNum {
+				ver.commitTs = v.clock
+			}
+		}
+	}
+	v.end(txNum)
+}
+
+// Abort 事务回滚之后调用，页面上的值已经被恢复了，删除事务留下的版本
+func (v *VersionStore) Abort(txNum uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.remove(txNum, v.written[txNum])
+	v.end(txNum)
+}
+
+// Mark 返回事务目前修改过的数据个数，设置保存点时记录下来，回滚到保存点时传给AbortTo
+func (v *VersionStore) Mark(txNum uint64) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.written[txNum])
+}
+
+/*
+AbortTo 事务回滚到保存点之后调用，删除保存点之后第一次修改的数据留下的版本，这些数据在页面上已经恢复成了修改之前的值。
+保存点之前已经修改过的数据保留版本，版本中是事务开始修改之前的值，仍然有效
+*/
+func (v *VersionStore) AbortTo(txNum uint64, mark int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	written := v.written[txNum]
+	if mark >= len(written) {
+		return
+	}
+	v.remove(txNum, written[mark:])
+	v.written[txNum] = written[:mark]
+}
+
+// remove 删除事务在给定数据上的版本，调用者需要持有锁
+func (v *VersionStore) remove(txNum uint64, cells []cellKey) {
+	for _, cell := range cells {
+		chain := v.chains[cell]
+		kept := chain[:0]
+		for _, ver := range chain {
+			if ver.writer != txNum {
+				kept = append(kept, ver)
+			}
+		}
+		v.setChain(cell, kept)
+	}
+}
+
+func (v *VersionStore) end(txNum uint64) {
+	delete(v.active, txNum)
+	delete(v.written, txNum)
+	v.gc()
+}
+
+func (v *VersionStore) setChain(cell cellKey, chain []*version) {
+	if len(chain) == 0 {
+		delete(v.chains, cell)
+		return
+	}
+	v.chains[cell] = chain
+}
+
+/*
+gc 删除所有活跃快照都不再需要的版本。一个已提交的修改如果对最老的快照可见，读数据时沿着版本链
+走到它就会停下，它和比它更旧的版本都不会再被用到。调用者需要持有锁
+*/
+func (v *VersionStore) gc() {
+	oldest := v.clock
+	for _, snapshot := range v.active {
+		if snapshot < oldest {
+			oldest = snapshot
+		}
+	}
+	for cell, chain := range v.chains {
+		for i, ver := range chain {
+			if ver.commitTs != 0 && ver.commitTs <= oldest {
+				v.setChain(cell, chain[:i])
+				break
+			}
+		}
+	}
+}
+
+// Len 返回版本存储中保存的版本数
+func (v *VersionStore) Len() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	n := 0
+	for _, chain := range v.chains {
+		n += len(chain)
+	}
+	return n
+}
@@ -0,0 +1,103 @@
+package transaction_manager
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	fm "simpleDb/file_manager"
+	"testing"
+	"time"
+)
+
+func TestTransaction_SnapshotRead(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 8)
+	blk := fm.NewBlockId("test_file", 1)
+
+	tx1 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx1.Pin(blk))
+	require.Nil(t, tx1.SetInt(blk, 80, 42, true))
+	require.Nil(t, tx1.SetString(blk, 100, "new", true))
+	val, err := tx1.GetInt(blk, 80)
+	require.Nil(t, err)
+	require.Equal(t, uint64(42), val)
+
+	// tx2读不到没有提交的修改，也不会被tx1的排他锁阻塞
+	tx2 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx2.Pin(blk))
+	val, err = tx2.GetInt(blk, 80)
+	require.Nil(t, err)
+	require.Equal(t, uint64(0), val)
+	tx1.Commit()
+
+	// tx1提交之后tx2仍然看到自己快照中的值
+	val, err = tx2.GetInt(blk, 80)
+	require.Nil(t, err)
+	require.Equal(t, uint64(0), val)
+	s, err := tx2.GetString(blk, 100)
+	require.Nil(t, err)
+	require.Equal(t, "", s)
+
+	tx3 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx3.Pin(blk))
+	val, err = tx3.GetInt(blk, 80)
+	require.Nil(t, err)
+	require.Equal(t, uint64(42), val)
+	// 读数据不加锁，tx3修改tx2读过的区块不需要等待
+	require.Nil(t, tx3.SetInt(blk, 80, 43, true))
+	tx3.Commit()
+	tx2.Commit()
+}
+
+func TestTransaction_FirstCommitterWins(t *testing.T) {
+	fileManager, logManager, bufferManager := newTestDb(t, 8)
+	blk := fm.NewBlockId("test_file", 1)
+
+	tx1 := NewTransaction(fileManager, logManager, bufferManager)
+	tx2 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx1.Pin(blk))
+	require.Nil(t, tx2.Pin(blk))
+	require.Nil(t, tx1.SetInt(blk, 80, 1, true))
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		tx1.Commit()
+	}()
+	// tx2等到tx1提交之后发现数据在自己的快照之后被修改了，被回滚
+	err := tx2.SetInt(blk, 80, 2, true)
+	require.True(t, errors.Is(err, ErrSerialization))
+
+	// 修改同一个区块中的其他数据不冲突
+	tx3 := NewTransaction(fileManager, logManager, bufferManager)
+	require.Nil(t, tx3.Pin(blk))
+	require.Nil(t, tx3.SetInt(blk, 88, 3, true))
+	val, err := tx3.GetInt(blk, 80)
+	require.Nil(t, err)
+	require.Equal(t, uint64(1), val)
+	tx3.Commit()
+}
+
+func TestVersionStore_GarbageCollection(t *testing.T) {
+	versions := NewVersionStore()
+	blk := fm.NewBlockId("test_file", 1)
+
+	snapshot := versions.Begin(1)
+	reader := versions.Begin(2)
+	require.Nil(t, versions.Write(1, snapshot, blk, 0, uint64(10)))
+	versions.Commit(1)
+	// 事务2的快照仍然需要修改之前的值
+	require.Equal(t, 1, versions.Len())
+	require.Equal(t, uint64(10), versions.Read(2, reader, blk, 0, uint64(11)))
+
+	snapshot = versions.Begin(3)
+	require.Nil(t, versions.Write(3, snapshot, blk, 0, uint64(11)))
+	versions.Commit(3)
+	require.Equal(t, 2, versions.Len())
+
+	// 最老的快照结束之后所有版本都不再需要了
+	versions.Commit(2)
+	require.Equal(t, 0, versions.Len())
+
+	snapshot = versions.Begin(4)
+	require.Nil(t, versions.Write(4, snapshot, blk, 0, uint64(12)))
+	versions.Abort(4)
+	require.Equal(t, 0, versions.Len())
+}